@@ -0,0 +1,401 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AggregateFunc is an aggregate function whose partial results can be
+// merged across shards.
+type AggregateFunc int
+
+// Supported aggregate functions.
+const (
+	// AggCount merges COUNT columns by adding them up.
+	AggCount AggregateFunc = iota + 1
+
+	// AggSum merges SUM columns by adding them up. Integer sums stay exact
+	// and fail on overflow, decimals returned as text are added exactly,
+	// and floats are added as floats.
+	AggSum
+
+	// AggMin merges MIN columns by keeping the smallest value. Numbers,
+	// strings and times can be compared.
+	AggMin
+
+	// AggMax merges MAX columns by keeping the largest value, compared like
+	// for AggMin.
+	AggMax
+
+	// AggAvg merges AVG from two columns, SUM and COUNT, as an average
+	// of averages is wrong whenever shards hold different row counts.
+	AggAvg
+)
+
+// Aggregation describes a per shard aggregate query. Each result row must
+// hold Keys GROUP BY columns followed by one column per function, or two
+// (sum and count, in that order) for AggAvg.
+type Aggregation struct {
+	Query string
+	Args  []interface{}
+	Keys  int
+	Funcs []AggregateFunc
+}
+
+// AggregateRow is a merged result row.
+type AggregateRow struct {
+	// Keys are the GROUP BY values. Byte slices are returned as strings.
+	Keys []interface{}
+
+	// Values hold one result per Aggregation function.
+	Values []AggregateValue
+}
+
+// AggregateValue is a merged aggregate result.
+type AggregateValue struct {
+	Func AggregateFunc
+
+	// Count is the result of AggCount and the number of rows behind
+	// AggAvg.
+	Count int64
+
+	// Float is the result of AggAvg.
+	Float float64
+
+	// Value is the result of AggSum, AggMin and AggMax, typed as returned
+	// by the driver: int64, float64, or string for decimals and other
+	// values returned as byte slices.
+	Value interface{}
+
+	// Valid is false when every shard returned NULL, as SUM, MIN, MAX and
+	// AVG do over no rows. It is always true for AggCount.
+	Valid bool
+}
+
+// Aggregate runs the Aggregation query against the reader of every Shard of
+// c and merges the partial results. Groups are returned in the order they
+// are first seen, going through the shards in order. Aggregates need every
+// shard, so any failure fails the whole call, even with CollectAll.
+func Aggregate(ctx context.Context, c Cluster, opts ScatterOptions, a Aggregation) ([]AggregateRow, error) {
+	if len(a.Funcs) == 0 {
+		return nil, cErr("no aggregate functions")
+	}
+	var (
+		parts = make(map[Shard][]AggregateRow)
+		mu    sync.Mutex
+	)
+	res := c.Scatter(ctx, opts, func(ctx context.Context, s Shard) error {
+		rows, err := a.query(ctx, s.Reader())
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		parts[s] = rows
+		mu.Unlock()
+		return nil
+	})
+	if err := res.Err(); err != nil {
+		return nil, err
+	}
+	var (
+		out    []AggregateRow
+		groups = make(map[string]int)
+	)
+	for _, s := range res.Succeeded {
+		for _, row := range parts[s] {
+			k := groupKey(row.Keys)
+			i, exists := groups[k]
+			if !exists {
+				groups[k] = len(out)
+				out = append(out, row)
+				continue
+			}
+			for j := range row.Values {
+				if err := out[i].Values[j].merge(row.Values[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	for i := range out {
+		for j := range out[i].Values {
+			out[i].Values[j].finish()
+		}
+	}
+	return out, nil
+}
+
+// query runs the aggregation on db and returns its partial rows. AggAvg
+// values carry the partial sum in Value until finish is called.
+func (a Aggregation) query(ctx context.Context, db *sql.DB) ([]AggregateRow, error) {
+	rows, err := db.QueryContext(ctx, a.Query, a.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if want := a.columns(); len(cols) != want {
+		return nil, cErr(fmt.Sprintf("aggregate query returned %d columns, want %d", len(cols), want))
+	}
+	var res []AggregateRow
+	for rows.Next() {
+		keys := make([]interface{}, a.Keys)
+		dest := make([]interface{}, 0, len(cols))
+		for i := range keys {
+			dest = append(dest, &keys[i])
+		}
+		var (
+			counts = make([]sql.NullInt64, len(a.Funcs))
+			values = make([]interface{}, len(a.Funcs))
+		)
+		for i, fn := range a.Funcs {
+			switch fn {
+			case AggCount:
+				dest = append(dest, &counts[i])
+			case AggSum, AggMin, AggMax:
+				dest = append(dest, &values[i])
+			case AggAvg:
+				dest = append(dest, &values[i], &counts[i])
+			}
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		for i, k := range keys {
+			keys[i] = noBytes(k)
+		}
+		row := AggregateRow{Keys: keys, Values: make([]AggregateValue, len(a.Funcs))}
+		for i, fn := range a.Funcs {
+			v := AggregateValue{Func: fn}
+			switch fn {
+			case AggCount:
+				v.Count, v.Valid = counts[i].Int64, true
+			case AggMin, AggMax:
+				v.Value, v.Valid = noBytes(values[i]), values[i] != nil
+			case AggSum, AggAvg:
+				v.Value, v.Count = noBytes(values[i]), counts[i].Int64
+				v.Valid = values[i] != nil && (fn == AggSum || v.Count > 0)
+				if v.Valid {
+					// Checks the value is a number.
+					if _, err := addValues(v.Value, int64(0)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			row.Values[i] = v
+		}
+		res = append(res, row)
+	}
+	return res, rows.Err()
+}
+
+// noBytes returns byte slices as strings, as the driver may reuse them.
+func noBytes(v interface{}) interface{} {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return v
+}
+
+// columns returns the number of columns the query must return.
+func (a Aggregation) columns() int {
+	n := a.Keys
+	for _, fn := range a.Funcs {
+		n++
+		if fn == AggAvg {
+			n++
+		}
+	}
+	return n
+}
+
+func (v *AggregateValue) merge(o AggregateValue) error {
+	switch v.Func {
+	case AggCount:
+		v.Count += o.Count
+	case AggSum, AggAvg:
+		if !o.Valid {
+			return nil
+		}
+		v.Count += o.Count
+		if !v.Valid {
+			v.Value, v.Valid = o.Value, true
+			return nil
+		}
+		sum, err := addValues(v.Value, o.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = sum
+	case AggMin, AggMax:
+		if !o.Valid {
+			return nil
+		}
+		if !v.Valid {
+			v.Value, v.Valid = o.Value, true
+			return nil
+		}
+		cmp, err := compareValues(o.Value, v.Value)
+		if err != nil {
+			return err
+		}
+		if v.Func == AggMin && cmp < 0 || v.Func == AggMax && cmp > 0 {
+			v.Value = o.Value
+		}
+	}
+	return nil
+}
+
+// compareValues compares two driver values, returning -1, 0 or 1. Integers
+// and floats are compared with each other, as drivers may return either
+// for the same column.
+func compareValues(a, b interface{}) (int, error) {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return order(x < y, x > y), nil
+		case float64:
+			return order(float64(x) < y, float64(x) > y), nil
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return order(x < float64(y), x > float64(y)), nil
+		case float64:
+			return order(x < y, x > y), nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return order(x.Before(y), x.After(y)), nil
+		}
+	}
+	return 0, cErr(fmt.Sprintf("cannot compare %T and %T", a, b))
+}
+
+func order(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	}
+	return 0
+}
+
+// addValues adds two numbers returned by drivers. Integers are added
+// exactly, and decimals returned as text too, keeping the largest scale;
+// floats turn the sum into a float.
+func addValues(a, b interface{}) (interface{}, error) {
+	x, xInt := a.(int64)
+	y, yInt := b.(int64)
+	if xInt && yInt {
+		sum := x + y
+		if (x > 0 && y > 0 && sum < 0) || (x < 0 && y < 0 && sum >= 0) {
+			return nil, cErr("sum overflows int64")
+		}
+		return sum, nil
+	}
+	_, xFloat := a.(float64)
+	_, yFloat := b.(float64)
+	if xFloat || yFloat || isFloatText(a) || isFloatText(b) {
+		fx, err := toFloat(a)
+		if err != nil {
+			return nil, err
+		}
+		fy, err := toFloat(b)
+		if err != nil {
+			return nil, err
+		}
+		return fx + fy, nil
+	}
+	rx, sx, err := toDecimal(a)
+	if err != nil {
+		return nil, err
+	}
+	ry, sy, err := toDecimal(b)
+	if err != nil {
+		return nil, err
+	}
+	if sy > sx {
+		sx = sy
+	}
+	return rx.Add(rx, ry).FloatString(sx), nil
+}
+
+// isFloatText reports whether v is a number in exponent notation, which
+// only float columns are returned as.
+func isFloatText(v interface{}) bool {
+	s, ok := v.(string)
+	return ok && strings.ContainsAny(s, "eE")
+}
+
+func toFloat(v interface{}) (float64, error) {
+	switch x := v.(type) {
+	case int64:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err == nil {
+			return f, nil
+		}
+	}
+	return 0, cErr(fmt.Sprintf("cannot add %T %v", v, v))
+}
+
+// toDecimal returns the exact value of an integer or a decimal string, and
+// the number of digits after its decimal point.
+func toDecimal(v interface{}) (*big.Rat, int, error) {
+	switch x := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(x), 0, nil
+	case string:
+		x = strings.TrimSpace(x)
+		if r, ok := new(big.Rat).SetString(x); ok && !strings.Contains(x, "/") {
+			scale := 0
+			if i := strings.IndexByte(x, '.'); i != -1 {
+				scale = len(x) - i - 1
+			}
+			return r, scale, nil
+		}
+	}
+	return nil, 0, cErr(fmt.Sprintf("cannot add %T %v", v, v))
+}
+
+func (v *AggregateValue) finish() {
+	if v.Func != AggAvg {
+		return
+	}
+	if v.Valid {
+		if r, _, err := toDecimal(v.Value); err == nil {
+			v.Float, _ = r.Quo(r, new(big.Rat).SetInt64(v.Count)).Float64()
+		} else {
+			f, _ := toFloat(v.Value)
+			v.Float = f / float64(v.Count)
+		}
+	}
+	v.Value = nil
+}
+
+func groupKey(keys []interface{}) string {
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%T:%v\x00", k, k)
+	}
+	return b.String()
+}
@@ -0,0 +1,178 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newAggregateCluster(t *testing.T, name string, results ...*fakeRows) Cluster {
+	t.Helper()
+	fs, c := newFakeCluster(t, name, len(results))
+	for i, rows := range results {
+		rows := rows
+		fs[i].query = func(string, []driver.NamedValue) (driver.Rows, error) {
+			if rows == nil {
+				return nil, errors.New("boom")
+			}
+			return rows, nil
+		}
+	}
+	return c
+}
+
+func TestAggregate(t *testing.T) {
+	const cols = "region,count,sum,min,max,avg_sum,avg_count"
+	c := newAggregateCluster(t, "aggregate",
+		newFakeRows(cols,
+			[]driver.Value{[]byte("eu"), int64(2), 10.0, 4.0, 6.0, 10.0, int64(2)},
+			[]driver.Value{[]byte("us"), int64(1), 3.0, 3.0, 3.0, 3.0, int64(1)},
+		),
+		newFakeRows(cols,
+			[]driver.Value{[]byte("us"), int64(3), 30.0, int64(1), int64(20), 30.0, int64(3)},
+			[]driver.Value{[]byte("asia"), int64(0), nil, nil, nil, nil, int64(0)},
+		),
+		newFakeRows(cols,
+			[]driver.Value{[]byte("eu"), int64(1), 2.0, 2.0, 2.0, 2.0, int64(1)},
+		),
+	)
+	got, err := Aggregate(context.Background(), c, ScatterOptions{}, Aggregation{
+		Query: "SELECT region, COUNT(*), SUM(v), MIN(v), MAX(v), SUM(v), COUNT(v) FROM t GROUP BY region",
+		Keys:  1,
+		Funcs: []AggregateFunc{AggCount, AggSum, AggMin, AggMax, AggAvg},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AggregateRow{
+		{[]interface{}{"eu"}, []AggregateValue{
+			{AggCount, 3, 0, nil, true},
+			{AggSum, 0, 0, 12.0, true},
+			{AggMin, 0, 0, 2.0, true},
+			{AggMax, 0, 0, 6.0, true},
+			{AggAvg, 3, 4, nil, true},
+		}},
+		{[]interface{}{"us"}, []AggregateValue{
+			{AggCount, 4, 0, nil, true},
+			{AggSum, 0, 0, 33.0, true},
+			{AggMin, 0, 0, int64(1), true},
+			{AggMax, 0, 0, int64(20), true},
+			{AggAvg, 4, 8.25, nil, true},
+		}},
+		{[]interface{}{"asia"}, []AggregateValue{
+			{AggCount, 0, 0, nil, true},
+			{AggSum, 0, 0, nil, false},
+			{AggMin, 0, 0, nil, false},
+			{AggMax, 0, 0, nil, false},
+			{AggAvg, 0, 0, nil, false},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregate_typed(t *testing.T) {
+	const cols = "min_name,max_name,min_at,max_at"
+	early, late := time.Unix(100, 0).UTC(), time.Unix(200, 0).UTC()
+	c := newAggregateCluster(t, "aggregate-typed",
+		newFakeRows(cols, []driver.Value{[]byte("bob"), []byte("bob"), late, late}),
+		newFakeRows(cols, []driver.Value{"alice", "carol", early, early}),
+	)
+	got, err := Aggregate(context.Background(), c, ScatterOptions{}, Aggregation{
+		Query: "SELECT MIN(name), MAX(name), MIN(at), MAX(at) FROM t",
+		Funcs: []AggregateFunc{AggMin, AggMax, AggMin, AggMax},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AggregateRow{{[]interface{}{}, []AggregateValue{
+		{AggMin, 0, 0, "alice", true},
+		{AggMax, 0, 0, "carol", true},
+		{AggMin, 0, 0, early, true},
+		{AggMax, 0, 0, late, true},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregate_sum(t *testing.T) {
+	const cols = "ints,decimals,avg_sum,avg_count"
+	c := newAggregateCluster(t, "aggregate-sum",
+		newFakeRows(cols, []driver.Value{int64(1 << 53), []byte("0.10"), []byte("1.5"), int64(1)}),
+		newFakeRows(cols, []driver.Value{int64(1), []byte("1234567890123456789.2"), []byte("2"), int64(2)}),
+		newFakeRows(cols, []driver.Value{nil, nil, nil, int64(0)}),
+	)
+	got, err := Aggregate(context.Background(), c, ScatterOptions{}, Aggregation{
+		Query: "SELECT SUM(i), SUM(d), SUM(d), COUNT(d) FROM t",
+		Funcs: []AggregateFunc{AggSum, AggSum, AggAvg},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []AggregateRow{{[]interface{}{}, []AggregateValue{
+		{AggSum, 0, 0, int64(1<<53 + 1), true},
+		{AggSum, 0, 0, "1234567890123456789.30", true},
+		{AggAvg, 3, 3.5 / 3, nil, true},
+	}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Aggregate() = %v, want %v", got, want)
+	}
+}
+
+func TestAggregate_errors(t *testing.T) {
+	tests := []struct {
+		name string
+		c    Cluster
+		a    Aggregation
+	}{
+		{
+			"no functions",
+			newAggregateCluster(t, "aggregate-no-funcs", newFakeRows("count")),
+			Aggregation{Query: "SELECT COUNT(*) FROM t"},
+		},
+		{
+			"column mismatch",
+			newAggregateCluster(t, "aggregate-columns", newFakeRows("count")),
+			Aggregation{Query: "SELECT COUNT(*) FROM t", Funcs: []AggregateFunc{AggAvg}},
+		},
+		{
+			"incomparable",
+			newAggregateCluster(t, "aggregate-incomparable",
+				newFakeRows("min", []driver.Value{int64(1)}),
+				newFakeRows("min", []driver.Value{"a"}),
+			),
+			Aggregation{Query: "SELECT MIN(v) FROM t", Funcs: []AggregateFunc{AggMin}},
+		},
+		{
+			"sum overflow",
+			newAggregateCluster(t, "aggregate-overflow",
+				newFakeRows("sum", []driver.Value{int64(math.MaxInt64)}),
+				newFakeRows("sum", []driver.Value{int64(1)}),
+			),
+			Aggregation{Query: "SELECT SUM(v) FROM t", Funcs: []AggregateFunc{AggSum}},
+		},
+		{
+			"sum of text",
+			newAggregateCluster(t, "aggregate-sum-text", newFakeRows("sum", []driver.Value{"a"})),
+			Aggregation{Query: "SELECT SUM(v) FROM t", Funcs: []AggregateFunc{AggSum}},
+		},
+		{
+			"shard failure",
+			newAggregateCluster(t, "aggregate-failure", newFakeRows("count"), nil),
+			Aggregation{Query: "SELECT COUNT(*) FROM t", Funcs: []AggregateFunc{AggCount}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Aggregate(context.Background(), tt.c, ScatterOptions{CollectAll: true}, tt.a); err == nil {
+				t.Error("Aggregate() error = nil")
+			}
+		})
+	}
+}
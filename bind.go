@@ -0,0 +1,49 @@
+package cluster
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// BindStyle is the placeholder syntax of a database driver.
+type BindStyle int
+
+const (
+	// BindQuestion uses ? placeholders (MySQL, SQLite).
+	BindQuestion BindStyle = iota
+
+	// BindDollar uses $1, $2, ... placeholders (PostgreSQL).
+	BindDollar
+)
+
+var (
+	tablePattern = regexp.MustCompile("^[a-zA-Z_][a-zA-Z0-9_]*(\\.[a-zA-Z_][a-zA-Z0-9_]*)?$")
+)
+
+// rebind rewrites the ? placeholders of query to the bind style. Queries
+// must not contain literal question marks.
+func (b BindStyle) rebind(query string) string {
+	if b != BindDollar {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			sb.WriteByte(query[i])
+			continue
+		}
+		n++
+		sb.WriteByte('$')
+		sb.WriteString(strconv.Itoa(n))
+	}
+	return sb.String()
+}
+
+func validTable(table string) error {
+	if !tablePattern.MatchString(table) {
+		return cErr("invalid table name '" + table + "'")
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package cluster
+
+import "testing"
+
+func TestBindStyle_rebind(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     BindStyle
+		query string
+		want  string
+	}{
+		{"question", BindQuestion, "SELECT a FROM t WHERE b = ? AND c = ?", "SELECT a FROM t WHERE b = ? AND c = ?"},
+		{"dollar", BindDollar, "SELECT a FROM t WHERE b = ? AND c = ?", "SELECT a FROM t WHERE b = $1 AND c = $2"},
+		{"no placeholders", BindDollar, "SELECT 1", "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.rebind(tt.query); got != tt.want {
+				t.Errorf("rebind() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,38 +1,35 @@
 package cluster
 
 import (
+	"context"
+	"sync"
 	"sync/atomic"
 )
 
-// NewCluster returns a new Cluster.
-func NewCluster(gen Generator, com Combiner, shards ...Shard) (Cluster, error) {
+// NewCluster returns a new Cluster. If com is nil the default Combiner is
+// used, if pl is nil new IDs are placed with a weighted Placer.
+func NewCluster(gen Generator, com Combiner, pl Placer, shards ...Shard) (Cluster, error) {
 	if gen == nil {
 		return nil, cErr("id generator cannot be nil")
 	}
 	if com == nil {
 		com = defaultCombiner
 	}
-	l := len(shards)
-	if l == 0 {
+	if pl == nil {
+		pl = NewWeightedPlacer()
+	}
+	if len(shards) == 0 {
 		return nil, cErr("cannot init cluster without shards")
 	}
 	c := &cluster{
 		gen: gen,
 		com: com,
-		ss:  make([]Shard, l),
-		ws:  make([]Shard, 0, l),
-		ms:  make(map[string]Shard, l),
+		pl:  pl,
 	}
 	if err := c.validate(shards); err != nil {
 		return nil, wrapErr(err, "shard validation failed")
 	}
-	for i, s := range shards {
-		c.ss[i] = s
-		c.ms[s.ID()] = s
-		if !s.ReadOnly() {
-			c.ws = append(c.ws, s)
-		}
-	}
+	c.top.Store(newTopology(shards))
 	return c, nil
 }
 
@@ -44,30 +41,124 @@ type Cluster interface {
 	// Many returns a map of Shards with slice of corresponding IDs as value.
 	Many(...string) (map[Shard][]string, error)
 
+	// ManyPartial is like Many, but does not stop at IDs that cannot be
+	// resolved. They are returned in a separate map with their error,
+	// which is nil if every ID was resolved.
+	ManyPartial(...string) (map[Shard][]string, map[string]error)
+
 	// All returns all Shards.
 	All() []Shard
 
-	// Next returns a new (generated) ID and corresponding Shard.
+	// Next returns a new (generated) ID and corresponding Shard. The Shard
+	// is chosen by the Cluster Placer among the Shards that are healthy and
+	// not read only at the time of the call. It fails with
+	// ErrIdCombineFailed if the Combiner cannot combine the generated ID.
 	Next() (string, Shard, error)
+
+	// OneContext is like One, but fails with the context error if ctx is
+	// already done.
+	OneContext(context.Context, string) (Shard, error)
+
+	// ManyContext is like Many, but fails with the context error if ctx is
+	// already done.
+	ManyContext(context.Context, ...string) (map[Shard][]string, error)
+
+	// NextContext is like Next, but passes ctx to Generators implementing
+	// GeneratorContext and Placers implementing PlacerContext.
+	NextContext(context.Context) (string, Shard, error)
+
+	// AddShard adds a Shard to the Cluster. The Shard is validated the same
+	// way as the ones passed to NewCluster.
+	AddShard(Shard) error
+
+	// RemoveShard removes a Shard by its ID and returns it. IDs pointing to
+	// the removed Shard can no longer be resolved.
+	RemoveShard(string) (Shard, error)
+
+	// ReplaceShard atomically swaps the Shard with the same ID as the given
+	// one and returns the replaced Shard, which is left open.
+	ReplaceShard(Shard) (Shard, error)
+
+	// Scatter runs a function against all Shards concurrently and reports
+	// which of them succeeded or failed.
+	Scatter(context.Context, ScatterOptions, ScatterFunc) *ScatterResult
+
+	// ExecMany groups IDs by Shard like Many and runs a function for every
+	// group concurrently, reporting the result per Shard. It fails without
+	// running anything if an ID cannot be resolved.
+	ExecMany(context.Context, ScatterOptions, []string, BatchFunc) (*ScatterResult, error)
+
+	// WithTx runs a function in a transaction on the primary database of
+	// the Shard the ID belongs to. The transaction is committed if the
+	// function succeeds and rolled back if it fails or panics. Nil options
+	// start a default transaction without retries.
+	WithTx(context.Context, string, *TxOptions, TxFunc) error
 }
 
+// BatchFunc is run by ExecMany against a single Shard with the IDs that
+// belong to it.
+type BatchFunc func(context.Context, Shard, []string) error
+
 type cluster struct {
 	gen Generator
 	com Combiner
-	ss  []Shard
-	ws  []Shard
-	ms  map[string]Shard
-	n   uint64
+	pl  Placer
+	top atomic.Value
+	mu  sync.Mutex
+}
+
+// topology is an immutable snapshot of the cluster shards. It is replaced
+// as a whole on every change, so readers never need to lock.
+type topology struct {
+	ss []Shard
+	ms map[string]Shard
+}
+
+func newTopology(shards []Shard) *topology {
+	t := &topology{
+		ss: make([]Shard, len(shards)),
+		ms: make(map[string]Shard, len(shards)),
+	}
+	for i, s := range shards {
+		t.ss[i] = s
+		t.ms[s.ID()] = s
+	}
+	return t
 }
 
 func (c *cluster) One(id string) (Shard, error) {
-	return c.shardById(id)
+	return c.shardById(c.topology(), id)
 }
 
 func (c *cluster) Many(ids ...string) (map[Shard][]string, error) {
+	return c.many(c.topology(), ids)
+}
+
+func (c *cluster) ManyPartial(ids ...string) (map[Shard][]string, map[string]error) {
+	t := c.topology()
+	var failed map[string]error
 	res := make(map[Shard][]string)
 	for _, id := range ids {
-		s, err := c.shardById(id)
+		s, err := c.shardById(t, id)
+		if err != nil {
+			if failed == nil {
+				failed = make(map[string]error)
+			}
+			failed[id] = err
+			continue
+		}
+		if _, exists := res[s]; !exists {
+			res[s] = make([]string, 0, len(ids))
+		}
+		res[s] = append(res[s], id)
+	}
+	return res, failed
+}
+
+func (c *cluster) many(t *topology, ids []string) (map[Shard][]string, error) {
+	res := make(map[Shard][]string)
+	for _, id := range ids {
+		s, err := c.shardById(t, id)
 		if err != nil {
 			return res, err
 		}
@@ -80,30 +171,186 @@ func (c *cluster) Many(ids ...string) (map[Shard][]string, error) {
 }
 
 func (c *cluster) All() []Shard {
-	res := make([]Shard, len(c.ss))
-	copy(res, c.ss)
+	t := c.topology()
+	res := make([]Shard, len(t.ss))
+	copy(res, t.ss)
 	return res
 }
 
 func (c *cluster) Next() (string, Shard, error) {
-	if len(c.ws) == 0 {
+	return c.NextContext(context.Background())
+}
+
+func (c *cluster) OneContext(ctx context.Context, id string) (Shard, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.One(id)
+}
+
+func (c *cluster) ManyContext(ctx context.Context, ids ...string) (map[Shard][]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Many(ids...)
+}
+
+func (c *cluster) NextContext(ctx context.Context) (string, Shard, error) {
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	s := c.next(ctx)
+	if s == nil {
 		return "", nil, ErrNoWritableShard
 	}
-	s := c.next()
-	return c.com.Combine(c.gen.Generate(), s.ID()), s, nil
+	id, err := c.generate(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	cid := c.com.Combine(id, s.ID())
+	if cid == "" {
+		return "", nil, ErrIdCombineFailed
+	}
+	return cid, s, nil
+}
+
+func (c *cluster) AddShard(s Shard) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.topology()
+	shards := make([]Shard, len(t.ss), len(t.ss)+1)
+	copy(shards, t.ss)
+	shards = append(shards, s)
+	if err := c.validate(shards); err != nil {
+		return wrapErr(err, "shard validation failed")
+	}
+	c.top.Store(newTopology(shards))
+	return nil
+}
+
+func (c *cluster) RemoveShard(id string) (Shard, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := c.topology()
+	s, exists := t.ms[id]
+	if !exists {
+		return nil, ErrShardNotFound
+	}
+	if len(t.ss) == 1 {
+		return nil, cErr("cannot remove the last shard")
+	}
+	shards := make([]Shard, 0, len(t.ss)-1)
+	for _, v := range t.ss {
+		if v != s {
+			shards = append(shards, v)
+		}
+	}
+	c.top.Store(newTopology(shards))
+	return s, nil
 }
 
-func (c *cluster) next() Shard {
-	n := atomic.AddUint64(&c.n, 1)
-	return c.ws[(int(n)-1)%len(c.ws)]
+func (c *cluster) ReplaceShard(s Shard) (Shard, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s == nil {
+		return nil, cErr("shard is nil")
+	}
+	t := c.topology()
+	old, exists := t.ms[s.ID()]
+	if !exists {
+		return nil, ErrShardNotFound
+	}
+	shards := make([]Shard, len(t.ss))
+	for i, v := range t.ss {
+		if v == old {
+			v = s
+		}
+		shards[i] = v
+	}
+	if err := c.validate(shards); err != nil {
+		return nil, wrapErr(err, "shard validation failed")
+	}
+	c.top.Store(newTopology(shards))
+	return old, nil
+}
+
+func (c *cluster) Scatter(ctx context.Context, opts ScatterOptions, fn ScatterFunc) *ScatterResult {
+	return scatter(ctx, c.topology().ss, opts, fn)
 }
 
-func (c *cluster) shardById(id string) (Shard, error) {
+// ExecMany runs the batches in topology order, so results are stable for
+// the same input.
+func (c *cluster) ExecMany(ctx context.Context, opts ScatterOptions, ids []string, fn BatchFunc) (*ScatterResult, error) {
+	t := c.topology()
+	groups, err := c.many(t, ids)
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]Shard, 0, len(groups))
+	for _, s := range t.ss {
+		if _, exists := groups[s]; exists {
+			shards = append(shards, s)
+		}
+	}
+	return scatter(ctx, shards, opts, func(ctx context.Context, s Shard) error {
+		return fn(ctx, s, groups[s])
+	}), nil
+}
+
+func (c *cluster) WithTx(ctx context.Context, id string, opts *TxOptions, fn TxFunc) error {
+	s, err := c.OneContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	db, err := s.WriterContext(ctx)
+	if err != nil {
+		return err
+	}
+	return withTx(ctx, db, opts, fn)
+}
+
+func (c *cluster) generate(ctx context.Context) (string, error) {
+	if g, ok := c.gen.(GeneratorContext); ok {
+		return g.GenerateContext(ctx)
+	}
+	return c.gen.Generate(), nil
+}
+
+func (c *cluster) topology() *topology {
+	return c.top.Load().(*topology)
+}
+
+// next collects the currently writable shards into a pooled buffer and
+// lets the placer choose among them, so read only flips take effect
+// immediately without any locking on the hot path.
+func (c *cluster) next(ctx context.Context) Shard {
+	buf := shardBufs.Get().(*[]Shard)
+	ws := (*buf)[:0]
+	for _, s := range c.topology().ss {
+		if writable(s) {
+			ws = append(ws, s)
+		}
+	}
+	var s Shard
+	if pl, ok := c.pl.(PlacerContext); ok {
+		s = pl.PlaceContext(ctx, ws)
+	} else {
+		s = c.pl.Place(ws)
+	}
+	for i := range ws {
+		ws[i] = nil
+	}
+	*buf = ws
+	shardBufs.Put(buf)
+	return s
+}
+
+func (c *cluster) shardById(t *topology, id string) (Shard, error) {
 	_, sid, err := c.com.Extract(id)
 	if err != nil {
 		return nil, err
 	}
-	if s, exists := c.ms[sid]; exists {
+	if s, exists := t.ms[sid]; exists {
 		return s, nil
 	}
 	return nil, ErrShardNotFound
@@ -112,6 +359,9 @@ func (c *cluster) shardById(id string) (Shard, error) {
 func (c *cluster) validate(shards []Shard) error {
 	uniq := make(map[string]struct{}, len(shards))
 	for _, s := range shards {
+		if s == nil {
+			return cErr("shard is nil")
+		}
 		if s.ID() == "" {
 			return cErr("shard id is empty")
 		}
@@ -121,6 +371,11 @@ func (c *cluster) validate(shards []Shard) error {
 		if s.Conn() == nil {
 			return cErr("database connection is nil")
 		}
+		for _, r := range s.Replicas() {
+			if r == nil {
+				return cErr("replica database connection is nil")
+			}
+		}
 		if _, exists := uniq[s.ID()]; exists {
 			return cErr("duplicate shard id '" + s.ID() + "'")
 		}
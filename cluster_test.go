@@ -1,9 +1,13 @@
 package cluster
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 )
@@ -22,6 +26,12 @@ func (t *tig) Generate() string {
 	return strconv.FormatUint(id, 16)
 }
 
+func newTestCluster(gen Generator, com Combiner, pl Placer, t *topology) *cluster {
+	c := &cluster{gen: gen, com: com, pl: pl}
+	c.top.Store(t)
+	return c
+}
+
 func TestNewCluster(t *testing.T) {
 	shards := []Shard{
 		NewShard("000001", &sql.DB{}, false),
@@ -36,6 +46,7 @@ func TestNewCluster(t *testing.T) {
 	type args struct {
 		idGen  Generator
 		com    Combiner
+		pl     Placer
 		shards []Shard
 	}
 	tests := []struct {
@@ -44,39 +55,40 @@ func TestNewCluster(t *testing.T) {
 		want    Cluster
 		wantErr bool
 	}{
-		{"ok", args{testIdGen, defaultCombiner, shards},
-			&cluster{
-				testIdGen,
-				defaultCombiner,
-				append(make([]Shard, 0, 3), shards...),
-				append(make([]Shard, 0, 3), shards[0]),
-				map[string]Shard{
+		{"ok", args{testIdGen, defaultCombiner, nil, shards},
+			newTestCluster(testIdGen, defaultCombiner, NewWeightedPlacer(), &topology{
+				ss: append(make([]Shard, 0, 3), shards...),
+				ms: map[string]Shard{
 					"000001": shards[0],
 					"000002": shards[1],
 					"000003": shards[2],
 				},
-				0,
-			}, false},
-		{"ok without combiner", args{testIdGen, nil, shards},
-			&cluster{
-				testIdGen,
-				defaultCombiner,
-				append(make([]Shard, 0, 3), shards...),
-				append(make([]Shard, 0, 3), shards[0]),
-				map[string]Shard{
+			}), false},
+		{"ok without combiner", args{testIdGen, nil, nil, shards},
+			newTestCluster(testIdGen, defaultCombiner, NewWeightedPlacer(), &topology{
+				ss: append(make([]Shard, 0, 3), shards...),
+				ms: map[string]Shard{
 					"000001": shards[0],
 					"000002": shards[1],
 					"000003": shards[2],
 				},
-				0,
-			}, false},
-		{"no idGen", args{nil, defaultCombiner, nil}, nil, true},
-		{"no shards", args{testIdGen, defaultCombiner, nil}, nil, true},
-		{"validation error", args{testIdGen, defaultCombiner, badShards}, nil, true},
+			}), false},
+		{"ok with placer", args{testIdGen, nil, NewRoundRobinPlacer(), shards},
+			newTestCluster(testIdGen, defaultCombiner, NewRoundRobinPlacer(), &topology{
+				ss: append(make([]Shard, 0, 3), shards...),
+				ms: map[string]Shard{
+					"000001": shards[0],
+					"000002": shards[1],
+					"000003": shards[2],
+				},
+			}), false},
+		{"no idGen", args{nil, defaultCombiner, nil, nil}, nil, true},
+		{"no shards", args{testIdGen, defaultCombiner, nil, nil}, nil, true},
+		{"validation error", args{testIdGen, defaultCombiner, nil, badShards}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewCluster(tt.args.idGen, tt.args.com, tt.args.shards...)
+			got, err := NewCluster(tt.args.idGen, tt.args.com, tt.args.pl, tt.args.shards...)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewCluster() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -103,7 +115,7 @@ func Test_cluster_All(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c, err := NewCluster(testIdGen, defaultCombiner, shards...)
+			c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 			if err != nil {
 				t.Error(err)
 				return
@@ -121,7 +133,7 @@ func Test_cluster_Many(t *testing.T) {
 		NewShard("000002", &sql.DB{}, true),
 		NewShard("000003", &sql.DB{}, true),
 	}
-	c, err := NewCluster(testIdGen, defaultCombiner, shards...)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -212,13 +224,72 @@ func Test_cluster_Many(t *testing.T) {
 	}
 }
 
+func Test_cluster_ManyPartial(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, true),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		name       string
+		ids        []string
+		want       map[Shard][]string
+		wantFailed map[string]error
+	}{
+		{
+			"ok",
+			[]string{"100@000001", "100@000002", "200@000001"},
+			map[Shard][]string{
+				shards[0]: {"100@000001", "200@000001"},
+				shards[1]: {"100@000002"},
+			},
+			nil,
+		},
+		{
+			"partial",
+			[]string{"100@000001", "100@000004", "100", "200@000001"},
+			map[Shard][]string{
+				shards[0]: {"100@000001", "200@000001"},
+			},
+			map[string]error{
+				"100@000004": ErrShardNotFound,
+				"100":        ErrIdParseFailed,
+			},
+		},
+		{
+			"none resolved",
+			[]string{"@000001", "100@000003"},
+			map[Shard][]string{},
+			map[string]error{
+				"@000001":    ErrIdParseFailed,
+				"100@000003": ErrShardNotFound,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, failed := c.ManyPartial(tt.ids...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ManyPartial() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(failed, tt.wantFailed) {
+				t.Errorf("ManyPartial() failed = %v, want %v", failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
 func Test_cluster_Next(t *testing.T) {
 	shards := []Shard{
 		NewShard("000001", &sql.DB{}, false),
 		NewShard("000002", &sql.DB{}, false),
 		NewShard("000003", &sql.DB{}, false),
 	}
-	c1, err := NewCluster(testIdGen, defaultCombiner, shards...)
+	c1, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -226,7 +297,7 @@ func Test_cluster_Next(t *testing.T) {
 	roShards := []Shard{
 		NewShard("000001", &sql.DB{}, true),
 	}
-	c2, err := NewCluster(testIdGen, defaultCombiner, roShards...)
+	c2, err := NewCluster(testIdGen, defaultCombiner, nil, roShards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -268,7 +339,7 @@ func Test_cluster_One(t *testing.T) {
 		NewShard("000002", &sql.DB{}, true),
 		NewShard("000003", &sql.DB{}, true),
 	}
-	c, err := NewCluster(testIdGen, defaultCombiner, shards...)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -305,13 +376,166 @@ func Test_cluster_One(t *testing.T) {
 	}
 }
 
+func Test_cluster_AddShard(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards[0])
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		name    string
+		shard   Shard
+		wantErr bool
+	}{
+		{"ok", shards[1], false},
+		{"duplicate shard id", NewShard("000002", &sql.DB{}, false), true},
+		{"invalid shard id", NewShard("2", &sql.DB{}, false), true},
+		{"nil database connection", NewShard("000003", nil, false), true},
+		{"nil shard", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := c.AddShard(tt.shard); (err != nil) != tt.wantErr {
+				t.Errorf("AddShard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := c.All(); !reflect.DeepEqual(got, shards) {
+		t.Errorf("All() = %v, want %v", got, shards)
+	}
+	if got, err := c.One("100@000002"); err != nil || got != shards[1] {
+		t.Errorf("One() got = %v, err = %v, want %v", got, err, shards[1])
+	}
+}
+
+func Test_cluster_RemoveShard(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	tests := []struct {
+		name    string
+		id      string
+		want    Shard
+		wantErr bool
+	}{
+		{"ok", "000001", shards[0], false},
+		{"not found", "000001", nil, true},
+		{"last shard", "000002", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.RemoveShard(tt.id)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RemoveShard() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RemoveShard() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if _, err := c.One("100@000001"); err != ErrShardNotFound {
+		t.Errorf("One() error = %v, want %v", err, ErrShardNotFound)
+	}
+	for i := 0; i < 3; i++ {
+		if _, got, err := c.Next(); err != nil || got != shards[1] {
+			t.Errorf("Next() got = %v, err = %v, want %v", got, err, shards[1])
+		}
+	}
+}
+
+func Test_cluster_ReplaceShard(t *testing.T) {
+	old := NewShard("000001", &sql.DB{}, false)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, old)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	replacement := NewShard("000001", &sql.DB{}, true)
+	tests := []struct {
+		name    string
+		s       Shard
+		want    Shard
+		wantErr bool
+	}{
+		{"ok", replacement, old, false},
+		{"not found", NewShard("000002", &sql.DB{}, false), nil, true},
+		{"nil", nil, nil, true},
+		{"no connection", NewShard("000001", nil, false), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.ReplaceShard(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReplaceShard() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ReplaceShard() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, err := c.One("100@000001"); err != nil || got != replacement {
+		t.Errorf("One() got = %v, err = %v, want %v", got, err, replacement)
+	}
+}
+
+func Test_cluster_concurrentTopology(t *testing.T) {
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, NewShard("000001", &sql.DB{}, false))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			s := NewShard(fmt.Sprintf("%06d", i+2), &sql.DB{}, false)
+			if err := c.AddShard(s); err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.RemoveShard(s.ID()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			id, _, err := c.Next()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := c.One(id); err != nil && err != ErrShardNotFound {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
 func Test_cluster_next(t *testing.T) {
 	shards := []Shard{
 		NewShard("000001", &sql.DB{}, false),
 		NewShard("000002", &sql.DB{}, false),
 		NewShard("000003", &sql.DB{}, false),
 	}
-	c, err := NewCluster(testIdGen, defaultCombiner, shards...)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -330,20 +554,77 @@ func Test_cluster_next(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := cl.next(); !reflect.DeepEqual(got, tt.want) {
+			if got := cl.next(context.Background()); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("next() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_cluster_next_readOnly(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, true),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	cl := c.(*cluster)
+	tests := []struct {
+		name string
+		ro   []bool
+		want []Shard
+	}{
+		{"initial", []bool{false, true}, []Shard{shards[0], shards[0]}},
+		{"unfreeze", []bool{false, false}, []Shard{shards[0], shards[1]}},
+		{"freeze", []bool{true, false}, []Shard{shards[1], shards[1]}},
+		{"all frozen", []bool{true, true}, []Shard{nil, nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, ro := range tt.ro {
+				shards[i].SetReadOnly(ro)
+			}
+			for i, want := range tt.want {
+				if got := cl.next(context.Background()); got != want {
+					t.Errorf("next() #%d = %v, want %v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_cluster_next_placer(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, NewLeastLoadedPlacer(func(s Shard) int {
+		if s.ID() == "000001" {
+			return 10
+		}
+		return 0
+	}), shards...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		if _, got, err := c.Next(); err != nil || got != shards[1] {
+			t.Errorf("Next() got = %v, err = %v, want %v", got, err, shards[1])
+		}
+	}
+}
+
 func Test_cluster_shardById(t *testing.T) {
 	shards := []Shard{
 		NewShard("000001", &sql.DB{}, false),
 		NewShard("000002", &sql.DB{}, false),
 		NewShard("000003", &sql.DB{}, false),
 	}
-	c, err := NewCluster(testIdGen, defaultCombiner, shards...)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
 	if err != nil {
 		t.Error(err)
 		return
@@ -366,7 +647,7 @@ func Test_cluster_shardById(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := cl.shardById(tt.args.id)
+			got, err := cl.shardById(cl.topology(), tt.args.id)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("shardById() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -379,7 +660,7 @@ func Test_cluster_shardById(t *testing.T) {
 }
 
 func Test_cluster_validate(t *testing.T) {
-	c, err := NewCluster(testIdGen, defaultCombiner, []Shard{
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, []Shard{
 		NewShard("000001", &sql.DB{}, false),
 		NewShard("000002", &sql.DB{}, true),
 		NewShard("000003", &sql.DB{}, true),
@@ -445,6 +726,15 @@ func Test_cluster_validate(t *testing.T) {
 			},
 			true,
 		},
+		{
+			"nil replica connection",
+			args{
+				[]Shard{
+					NewReplicatedShard("000001", &sql.DB{}, []*sql.DB{nil}, nil, false),
+				},
+			},
+			true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -454,3 +744,134 @@ func Test_cluster_validate(t *testing.T) {
 		})
 	}
 }
+
+func Test_cluster_ExecMany(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, true),
+		NewShard("000003", &sql.DB{}, true),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	boom := errors.New("boom")
+	var mu sync.Mutex
+	got := make(map[Shard][]string)
+	res, err := c.ExecMany(context.Background(), ScatterOptions{Limit: 1, CollectAll: true},
+		[]string{"1@000003", "2@000001", "3@000003"},
+		func(_ context.Context, s Shard, ids []string) error {
+			mu.Lock()
+			got[s] = ids
+			mu.Unlock()
+			if s == shards[0] {
+				return boom
+			}
+			return nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[Shard][]string{
+		shards[0]: {"2@000001"},
+		shards[2]: {"1@000003", "3@000003"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExecMany() batches = %v, want %v", got, want)
+	}
+	if !reflect.DeepEqual(res.Succeeded, []Shard{shards[2]}) {
+		t.Errorf("ExecMany() succeeded = %v", res.Succeeded)
+	}
+	if !reflect.DeepEqual(res.Failed, map[Shard]error{shards[0]: boom}) {
+		t.Errorf("ExecMany() failed = %v", res.Failed)
+	}
+	res, err = c.ExecMany(context.Background(), ScatterOptions{}, []string{"1@000001", "1@000004"},
+		func(context.Context, Shard, []string) error {
+			t.Error("ExecMany() ran with an unknown shard")
+			return nil
+		})
+	if err != ErrShardNotFound || res != nil {
+		t.Errorf("ExecMany() = %v, %v, want %v", res, err, ErrShardNotFound)
+	}
+}
+
+type ctxKey struct{}
+
+type ctxGen struct {
+	err error
+}
+
+func (g *ctxGen) Generate() string {
+	return "legacy"
+}
+
+func (g *ctxGen) GenerateContext(ctx context.Context) (string, error) {
+	if g.err != nil {
+		return "", g.err
+	}
+	return ctx.Value(ctxKey{}).(string), nil
+}
+
+type ctxPlacer struct {
+	got interface{}
+}
+
+func (p *ctxPlacer) Place(shards []Shard) Shard {
+	return nil
+}
+
+func (p *ctxPlacer) PlaceContext(ctx context.Context, shards []Shard) Shard {
+	p.got = ctx.Value(ctxKey{})
+	return shards[0]
+}
+
+func Test_cluster_NextContext(t *testing.T) {
+	shards := []Shard{NewShard("000001", &sql.DB{}, false)}
+	gen := &ctxGen{}
+	pl := &ctxPlacer{}
+	c, err := NewCluster(gen, defaultCombiner, pl, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "abc")
+	id, s, err := c.NextContext(ctx)
+	if err != nil || id != "abc@000001" || s != shards[0] {
+		t.Errorf("NextContext() = %v, %v, %v", id, s, err)
+	}
+	if pl.got != "abc" {
+		t.Errorf("PlaceContext() got context value %v", pl.got)
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := c.NextContext(cancelled); err != context.Canceled {
+		t.Errorf("NextContext() error = %v, want %v", err, context.Canceled)
+	}
+	boom := errors.New("boom")
+	gen.err = boom
+	if _, _, err := c.Next(); err != boom {
+		t.Errorf("Next() error = %v, want %v", err, boom)
+	}
+}
+
+func Test_cluster_OneContext(t *testing.T) {
+	shards := []Shard{NewShard("000001", &sql.DB{}, false)}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, err := c.OneContext(context.Background(), "1@000001"); err != nil || s != shards[0] {
+		t.Errorf("OneContext() = %v, %v", s, err)
+	}
+	if m, err := c.ManyContext(context.Background(), "1@000001"); err != nil || len(m[shards[0]]) != 1 {
+		t.Errorf("ManyContext() = %v, %v", m, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.OneContext(ctx, "1@000001"); err != context.Canceled {
+		t.Errorf("OneContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := c.ManyContext(ctx, "1@000001"); err != context.Canceled {
+		t.Errorf("ManyContext() error = %v, want %v", err, context.Canceled)
+	}
+}
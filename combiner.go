@@ -15,15 +15,21 @@ type Combiner interface {
 	// Validate shard ID.
 	Validate(string) bool
 
-	// Combine id and shardId into a single string.
+	// Combine id and shardId into a single string. An empty string means
+	// they cannot be combined.
 	Combine(string, string) string
 
 	// Extract id and shardId from a single string.
 	Extract(string) (string, string, error)
 }
 
+const (
+	defaultSeparator = "@"
+	defaultPattern   = "^[a-zA-Z0-9]{6}$"
+)
+
 var (
-	defaultCombiner = NewCombiner("@", regexp.MustCompile("^[a-zA-Z0-9]{6}$"))
+	defaultCombiner = NewCombiner(defaultSeparator, regexp.MustCompile(defaultPattern))
 )
 
 type combiner struct {
@@ -46,7 +52,7 @@ func (c *combiner) Extract(id string) (string, string, error) {
 		return "", "", ErrIdParseFailed
 	}
 	v := id[:i]
-	vs := id[i+1:]
+	vs := id[i+len(c.sep):]
 	if !c.Validate(vs) || len(v) == 0 {
 		return "", "", ErrIdParseFailed
 	}
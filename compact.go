@@ -0,0 +1,134 @@
+package cluster
+
+import (
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CompactEncoding is the alphabet of a compact Combiner.
+type CompactEncoding int
+
+const (
+	// Base62 uses digits and upper and lower case letters.
+	Base62 CompactEncoding = iota
+
+	// Base32 uses the Crockford alphabet. Decoding is case insensitive and
+	// accepts I and L for 1 and O for 0.
+	Base32
+)
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// NewCompactCombiner returns a Combiner encoding a local ID and a shard
+// number into a fixed length string. Local IDs must be decimal unsigned 64
+// bit integers; bits and shards define the shard numbers like for
+// NewNumericCombiner.
+func NewCompactCombiner(enc CompactEncoding, bits uint, shards map[int64]string) (Combiner, error) {
+	num, err := NewNumericCombiner(bits, shards)
+	if err != nil {
+		return nil, err
+	}
+	c := &compactCombiner{num: num, bits: bits}
+	switch enc {
+	case Base62:
+		c.alphabet = base62
+	case Base32:
+		c.alphabet = crockford
+	default:
+		return nil, cErr("unknown compact encoding")
+	}
+	for i := range c.index {
+		c.index[i] = -1
+	}
+	for i := 0; i < len(c.alphabet); i++ {
+		c.index[c.alphabet[i]] = int8(i)
+	}
+	if enc == Base32 {
+		for i := 0; i < len(c.alphabet); i++ {
+			c.index[c.alphabet[i]|0x20] = int8(i)
+		}
+		for _, a := range []struct {
+			alias byte
+			digit byte
+		}{{'I', '1'}, {'L', '1'}, {'O', '0'}} {
+			c.index[a.alias] = c.index[a.digit]
+			c.index[a.alias|0x20] = c.index[a.digit]
+		}
+	}
+	// The length is the number of digits of the largest value.
+	hi, lo := uint64(1)<<bits-1, ^uint64(0)
+	for hi > 0 || lo > 0 {
+		hi, lo, _ = divmod(hi, lo, uint64(len(c.alphabet)))
+		c.size++
+	}
+	return c, nil
+}
+
+// compactCombiner encodes local<<bits | number as a 128 bit integer, hi
+// holding the bits above the lowest 64 ones.
+type compactCombiner struct {
+	num      NumericCombiner
+	bits     uint
+	alphabet string
+	index    [256]int8
+	size     int
+}
+
+func (c *compactCombiner) Validate(shardId string) bool {
+	return c.num.Validate(shardId)
+}
+
+func (c *compactCombiner) Combine(id string, shardId string) string {
+	local, err := strconv.ParseUint(strings.TrimSpace(id), 10, 64)
+	if err != nil {
+		return ""
+	}
+	n, exists := c.num.Number(strings.TrimSpace(shardId))
+	if !exists {
+		return ""
+	}
+	hi, lo := local>>(64-c.bits), local<<c.bits|uint64(n)
+	b := make([]byte, c.size)
+	for i := len(b) - 1; i >= 0; i-- {
+		var r uint64
+		hi, lo, r = divmod(hi, lo, uint64(len(c.alphabet)))
+		b[i] = c.alphabet[r]
+	}
+	return string(b)
+}
+
+func (c *compactCombiner) Extract(id string) (string, string, error) {
+	id = strings.TrimSpace(id)
+	if len(id) != c.size {
+		return "", "", ErrIdParseFailed
+	}
+	var hi, lo uint64
+	for i := 0; i < len(id); i++ {
+		d := c.index[id[i]]
+		if d < 0 {
+			return "", "", ErrIdParseFailed
+		}
+		var carry, sum uint64
+		carry, lo = bits.Mul64(lo, uint64(len(c.alphabet)))
+		lo, sum = bits.Add64(lo, uint64(d), 0)
+		hi = hi*uint64(len(c.alphabet)) + carry + sum
+		if hi >= 1<<c.bits {
+			return "", "", ErrIdParseFailed
+		}
+	}
+	shardId, exists := c.num.ShardID(int64(lo & (1<<c.bits - 1)))
+	if !exists {
+		return "", "", ErrShardNotFound
+	}
+	local := hi<<(64-c.bits) | lo>>c.bits
+	return strconv.FormatUint(local, 10), shardId, nil
+}
+
+// divmod divides the 128 bit integer hi:lo by d and returns the quotient
+// and the remainder.
+func divmod(hi, lo, d uint64) (uint64, uint64, uint64) {
+	qhi, r := bits.Div64(0, hi, d)
+	qlo, r := bits.Div64(r, lo, d)
+	return qhi, qlo, r
+}
@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNewCompactCombiner(t *testing.T) {
+	tests := []struct {
+		name     string
+		enc      CompactEncoding
+		bits     uint
+		shards   map[int64]string
+		wantSize int
+		wantErr  bool
+	}{
+		{"base62", Base62, 4, map[int64]string{1: "000001"}, 12, false},
+		{"base62 wide", Base62, 16, map[int64]string{1: "000001"}, 14, false},
+		{"base32", Base32, 4, map[int64]string{1: "000001"}, 14, false},
+		{"base32 wide", Base32, 16, map[int64]string{1: "000001"}, 16, false},
+		{"unknown encoding", CompactEncoding(7), 4, map[int64]string{1: "000001"}, 0, true},
+		{"invalid shards", Base62, 4, map[int64]string{16: "000001"}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCompactCombiner(tt.enc, tt.bits, tt.shards)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCompactCombiner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && c.(*compactCombiner).size != tt.wantSize {
+				t.Errorf("NewCompactCombiner() size = %v, want %v", c.(*compactCombiner).size, tt.wantSize)
+			}
+		})
+	}
+}
+
+func Test_compactCombiner_Combine(t *testing.T) {
+	shards := map[int64]string{2: "000002", 15: "000015"}
+	b62, err := NewCompactCombiner(Base62, 4, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b32, err := NewCompactCombiner(Base32, 4, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	max := strconv.FormatUint(1<<64-1, 10)
+	tests := []struct {
+		name    string
+		c       Combiner
+		id      string
+		shardId string
+		want    string
+	}{
+		{"base62", b62, "12345", "000002", "000000000pNq"},
+		{"base62 max", b62, max, "000015", "5feuXIHaeWq7"},
+		{"base32", b32, "12345", "000002", "000000000060WJ"},
+		{"base32 max", b32, max, "000015", "7ZZZZZZZZZZZZZ"},
+		{"not a number", b62, "186a1", "000002", ""},
+		{"negative", b62, "-1", "000002", ""},
+		{"unknown shard", b62, "1", "000001", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.c.Combine(tt.id, tt.shardId)
+			if got != tt.want {
+				t.Fatalf("Combine() = %v, want %v", got, tt.want)
+			}
+			if got == "" {
+				return
+			}
+			id, shardId, err := tt.c.Extract(got)
+			if err != nil || id != tt.id || shardId != tt.shardId {
+				t.Errorf("Extract() = %v, %v, %v, want %v, %v", id, shardId, err, tt.id, tt.shardId)
+			}
+		})
+	}
+}
+
+func Test_compactCombiner_Extract(t *testing.T) {
+	shards := map[int64]string{2: "000002"}
+	b62, err := NewCompactCombiner(Base62, 4, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b32, err := NewCompactCombiner(Base32, 4, shards)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		c       Combiner
+		id      string
+		wantId  string
+		wantErr error
+	}{
+		{"base32 lower case", b32, "000000000060wj", "12345", nil},
+		{"base32 aliases", b32, "OOOOOOOOOO60WJ", "12345", nil},
+		{"base32 I and L", b32, "00000000001L12", "2114", nil},
+		{"too short", b62, "00000000pNq", "", ErrIdParseFailed},
+		{"too long", b62, "0000000000pNq", "", ErrIdParseFailed},
+		{"invalid character", b62, "00000000-pNq", "", ErrIdParseFailed},
+		{"base32 invalid character", b32, "00000000000U2", "", ErrIdParseFailed},
+		{"base62 overflow", b62, "5feuXIHaeWq8", "", ErrIdParseFailed},
+		{"base32 overflow", b32, "80000000000002", "", ErrIdParseFailed},
+		{"unmapped shard", b62, "000000000pNr", "", ErrShardNotFound},
+		{"empty", b62, "", "", ErrIdParseFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, _, err := tt.c.Extract(tt.id)
+			if err != tt.wantErr {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantId {
+				t.Errorf("Extract() = %v, want %v", id, tt.wantId)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package cluster
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Placement names accepted in Config.Placement.
+const (
+	PlacementWeighted    = "weighted"
+	PlacementRoundRobin  = "round-robin"
+	PlacementRandom      = "random"
+	PlacementLeastLoaded = "least-loaded"
+)
+
+// Replica policy names accepted in ShardConfig.ReplicaPolicy.
+const (
+	ReplicaRoundRobin    = "round-robin"
+	ReplicaRandom        = "random"
+	ReplicaLeastInFlight = "least-in-flight"
+)
+
+// Load reads the topology file at path and returns a ready Cluster.
+func Load(gen Generator, path string) (Cluster, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewClusterFromConfig(gen, cfg)
+}
+
+// LoadConfig reads and validates the JSON topology file at path.
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, wrapErr(err, "failed to open config")
+	}
+	defer f.Close()
+	return ReadConfig(f)
+}
+
+// ReadConfig decodes and validates a JSON topology from r.
+func ReadConfig(r io.Reader) (*Config, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	cfg := &Config{}
+	if err := dec.Decode(cfg); err != nil {
+		return nil, wrapErr(err, "failed to decode config")
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// NewClusterFromConfig opens a database connection for every configured
+// shard and returns a ready Cluster. Connections opened before a failure
+// are closed.
+func NewClusterFromConfig(gen Generator, cfg *Config) (Cluster, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	com, err := cfg.Combiner()
+	if err != nil {
+		return nil, err
+	}
+	pl, err := cfg.Placer()
+	if err != nil {
+		return nil, err
+	}
+	shards := make([]Shard, 0, len(cfg.Shards))
+	for i, sc := range cfg.Shards {
+		s, err := sc.Open()
+		if err != nil {
+			closeShards(shards)
+			return nil, wrapErr(err, cfg.entry(i))
+		}
+		shards = append(shards, s)
+	}
+	c, err := NewCluster(gen, com, pl, shards...)
+	if err != nil {
+		closeShards(shards)
+		return nil, err
+	}
+	return c, nil
+}
+
+// Config describes a Cluster topology.
+type Config struct {
+	// Separator used by the Combiner, "@" if empty.
+	Separator string `json:"separator"`
+
+	// Pattern shard IDs are validated against, the default Combiner
+	// pattern if empty.
+	Pattern string `json:"pattern"`
+
+	// Placement policy name, weighted if empty.
+	Placement string `json:"placement"`
+
+	// Shards of the Cluster.
+	Shards []ShardConfig `json:"shards"`
+}
+
+// ShardConfig describes a single Shard. Replicas are DSNs opened with the
+// same driver and pool limits as the primary.
+type ShardConfig struct {
+	ID              string   `json:"id"`
+	Driver          string   `json:"driver"`
+	DSN             string   `json:"dsn"`
+	Replicas        []string `json:"replicas"`
+	ReplicaPolicy   string   `json:"replica_policy"`
+	ReadOnly        bool     `json:"read_only"`
+	Weight          *int     `json:"weight"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time"`
+}
+
+// Validate checks the Config without opening any connection. Errors name
+// the offending shard entry.
+func (c *Config) Validate() error {
+	com, err := c.Combiner()
+	if err != nil {
+		return err
+	}
+	if _, err := c.Placer(); err != nil {
+		return err
+	}
+	if len(c.Shards) == 0 {
+		return cErr("config has no shards")
+	}
+	uniq := make(map[string]struct{}, len(c.Shards))
+	for i, sc := range c.Shards {
+		if err := sc.validate(com); err != nil {
+			return wrapErr(err, c.entry(i))
+		}
+		if _, exists := uniq[sc.ID]; exists {
+			return wrapErr(cErr("duplicate shard id"), c.entry(i))
+		}
+		uniq[sc.ID] = struct{}{}
+	}
+	return nil
+}
+
+// Combiner returns the Combiner described by the Config.
+func (c *Config) Combiner() (Combiner, error) {
+	if c.Separator == "" && c.Pattern == "" {
+		return defaultCombiner, nil
+	}
+	sep, pattern := c.Separator, c.Pattern
+	if sep == "" {
+		sep = defaultSeparator
+	} else if strings.TrimSpace(sep) == "" {
+		// NewCombiner trims the separator, which would leave IDs that
+		// cannot be split again.
+		return nil, cErr("separator is blank")
+	}
+	if pattern == "" {
+		pattern = defaultPattern
+	}
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, wrapErr(err, "invalid pattern")
+	}
+	return NewCombiner(sep, reg), nil
+}
+
+// Placer returns a new Placer for the configured placement policy.
+func (c *Config) Placer() (Placer, error) {
+	switch c.Placement {
+	case "", PlacementWeighted:
+		return NewWeightedPlacer(), nil
+	case PlacementRoundRobin:
+		return NewRoundRobinPlacer(), nil
+	case PlacementRandom:
+		return NewRandomPlacer(), nil
+	case PlacementLeastLoaded:
+		return NewLeastLoadedPlacer(nil), nil
+	}
+	return nil, cErr("unknown placement '" + c.Placement + "'")
+}
+
+func (c *Config) entry(i int) string {
+	e := "shards[" + strconv.Itoa(i) + "]"
+	if id := c.Shards[i].ID; id != "" {
+		e += " (" + id + ")"
+	}
+	return e
+}
+
+// Open connects to the shard databases and returns a new Shard.
+func (sc ShardConfig) Open() (Shard, error) {
+	rp, err := sc.replicaPolicy()
+	if err != nil {
+		return nil, err
+	}
+	db, err := sc.open(sc.DSN)
+	if err != nil {
+		return nil, err
+	}
+	if len(sc.Replicas) == 0 {
+		return NewWeightedShard(sc.ID, db, sc.ReadOnly, sc.weight()), nil
+	}
+	replicas := make([]*sql.DB, 0, len(sc.Replicas))
+	for _, dsn := range sc.Replicas {
+		r, err := sc.open(dsn)
+		if err != nil {
+			_ = db.Close()
+			for _, r := range replicas {
+				_ = r.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, r)
+	}
+	s := NewReplicatedShard(sc.ID, db, replicas, rp, sc.ReadOnly)
+	s.SetWeight(sc.weight())
+	return s, nil
+}
+
+func (sc ShardConfig) open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(sc.Driver, dsn)
+	if err != nil {
+		return nil, wrapErr(err, "failed to open database")
+	}
+	if sc.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(sc.MaxOpenConns)
+	}
+	if sc.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(sc.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(time.Duration(sc.ConnMaxLifetime))
+	db.SetConnMaxIdleTime(time.Duration(sc.ConnMaxIdleTime))
+	return db, nil
+}
+
+func (sc ShardConfig) replicaPolicy() (ReplicaPolicy, error) {
+	switch sc.ReplicaPolicy {
+	case "", ReplicaRoundRobin:
+		return NewRoundRobinReplicaPolicy(), nil
+	case ReplicaRandom:
+		return NewRandomReplicaPolicy(), nil
+	case ReplicaLeastInFlight:
+		return NewLeastInFlightReplicaPolicy(), nil
+	}
+	return nil, cErr("unknown replica policy '" + sc.ReplicaPolicy + "'")
+}
+
+// sameConn reports whether sc connects to the same databases as o.
+func (sc ShardConfig) sameConn(o ShardConfig) bool {
+	if sc.Driver != o.Driver || sc.DSN != o.DSN || len(sc.Replicas) != len(o.Replicas) {
+		return false
+	}
+	for i, dsn := range sc.Replicas {
+		if o.Replicas[i] != dsn {
+			return false
+		}
+	}
+	return true
+}
+
+func (sc ShardConfig) weight() int {
+	if sc.Weight == nil {
+		return 1
+	}
+	return *sc.Weight
+}
+
+func (sc ShardConfig) validate(com Combiner) error {
+	for _, dsn := range sc.Replicas {
+		if dsn == "" {
+			return cErr("replica dsn is empty")
+		}
+	}
+	if _, err := sc.replicaPolicy(); err != nil {
+		return err
+	}
+	switch {
+	case sc.ID == "":
+		return cErr("shard id is empty")
+	case !com.Validate(sc.ID):
+		return cErr("invalid shard id")
+	case sc.Driver == "":
+		return cErr("driver is empty")
+	case sc.DSN == "":
+		return cErr("dsn is empty")
+	case sc.weight() < 0:
+		return cErr("weight is negative")
+	case sc.MaxOpenConns < 0:
+		return cErr("max_open_conns is negative")
+	case sc.MaxIdleConns < 0:
+		return cErr("max_idle_conns is negative")
+	case sc.ConnMaxLifetime < 0:
+		return cErr("conn_max_lifetime is negative")
+	case sc.ConnMaxIdleTime < 0:
+		return cErr("conn_max_idle_time is negative")
+	}
+	return nil
+}
+
+// Duration is a time.Duration that is encoded in JSON as a string such as
+// "1m30s". Plain numbers are read as nanoseconds.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(dur)
+	default:
+		return cErr("invalid duration " + string(b))
+	}
+	return nil
+}
+
+func closeShards(shards []Shard) {
+	for _, s := range shards {
+		_ = closeShard(s)
+	}
+}
+
+// closeShard closes the primary and replica connections of s.
+func closeShard(s Shard) error {
+	err := s.Writer().Close()
+	for _, r := range s.Replicas() {
+		if rerr := r.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package cluster
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		wantErr string
+	}{
+		{
+			"ok",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "one", "weight": 0},
+				{"id": "000002", "driver": "cluster-fake", "dsn": "two", "read_only": true,
+				 "max_open_conns": 10, "conn_max_lifetime": "5m"}
+			]}`,
+			"",
+		},
+		{
+			"custom combiner",
+			`{"separator": "#", "pattern": "^s[0-9]+$", "placement": "random", "shards": [
+				{"id": "s1", "driver": "cluster-fake", "dsn": "one"}
+			]}`,
+			"",
+		},
+		{
+			"replicas",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "one",
+				 "replicas": ["one-r1", "one-r2"], "replica_policy": "least-in-flight"}
+			]}`,
+			"",
+		},
+		{
+			"empty replica",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "one", "replicas": [""]}]}`,
+			"shards[0] (000001): replica dsn is empty",
+		},
+		{
+			"bad replica policy",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "one", "replica_policy": "nearest"}]}`,
+			"shards[0] (000001): unknown replica policy 'nearest'",
+		},
+		{"no shards", `{"shards": []}`, "config has no shards"},
+		{"unknown field", `{"shard": []}`, "failed to decode config"},
+		{"bad pattern", `{"pattern": "(", "shards": []}`, "invalid pattern"},
+		{"blank separator", `{"separator": " ", "shards": []}`, "separator is blank"},
+		{"bad placement", `{"placement": "magic", "shards": []}`, "unknown placement 'magic'"},
+		{
+			"empty id",
+			`{"shards": [{"driver": "cluster-fake", "dsn": "one"}]}`,
+			"shards[0]: shard id is empty",
+		},
+		{
+			"invalid id",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "one"},
+				{"id": "2", "driver": "cluster-fake", "dsn": "two"}
+			]}`,
+			"shards[1] (2): invalid shard id",
+		},
+		{
+			"duplicate id",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "one"},
+				{"id": "000001", "driver": "cluster-fake", "dsn": "two"}
+			]}`,
+			"shards[1] (000001): duplicate shard id",
+		},
+		{
+			"no driver",
+			`{"shards": [{"id": "000001", "dsn": "one"}]}`,
+			"shards[0] (000001): driver is empty",
+		},
+		{
+			"no dsn",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake"}]}`,
+			"shards[0] (000001): dsn is empty",
+		},
+		{
+			"negative weight",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "one", "weight": -1}]}`,
+			"shards[0] (000001): weight is negative",
+		},
+		{
+			"bad duration",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "one", "conn_max_idle_time": "soon"}]}`,
+			"failed to decode config",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadConfig(strings.NewReader(tt.json))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ReadConfig() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("ReadConfig() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	err := os.WriteFile(path, []byte(`{
+		"placement": "round-robin",
+		"shards": [
+			{"id": "000001", "driver": "cluster-fake", "dsn": "load-one", "weight": 3,
+			 "max_open_conns": 4, "conn_max_idle_time": 60000000000},
+			{"id": "000002", "driver": "cluster-fake", "dsn": "load-two", "read_only": true,
+			 "replicas": ["load-two-r1"], "weight": 2}
+		]
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(testIdGen, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards := c.All()
+	if len(shards) != 2 {
+		t.Fatalf("All() = %v, want 2 shards", shards)
+	}
+	if s := shards[0]; s.ID() != "000001" || s.ReadOnly() || s.Weight() != 3 {
+		t.Errorf("shard 0 = %v, readonly %v, weight %v", s.ID(), s.ReadOnly(), s.Weight())
+	}
+	if got := shards[0].Conn().Stats().MaxOpenConnections; got != 4 {
+		t.Errorf("MaxOpenConnections = %v, want 4", got)
+	}
+	if s := shards[1]; s.ID() != "000002" || !s.ReadOnly() || s.Weight() != 2 {
+		t.Errorf("shard 1 = %v, readonly %v, weight %v", s.ID(), s.ReadOnly(), s.Weight())
+	}
+	if got := shards[1].Replicas(); len(got) != 1 || shards[1].Reader() != got[0] {
+		t.Errorf("Replicas() = %v, Reader() = %p", got, shards[1].Reader())
+	}
+	if _, ok := c.(*cluster).pl.(*roundRobinPlacer); !ok {
+		t.Errorf("placer = %T, want round-robin", c.(*cluster).pl)
+	}
+	if _, err := Load(testIdGen, filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load() error = nil for missing file")
+	}
+}
+
+func TestNewClusterFromConfig(t *testing.T) {
+	cfg := &Config{Shards: []ShardConfig{
+		{ID: "000001", Driver: fakeDriverName, DSN: "one"},
+		{ID: "000002", Driver: "no-such-driver", DSN: "two"},
+	}}
+	_, err := NewClusterFromConfig(testIdGen, cfg)
+	if err == nil || !strings.HasPrefix(err.Error(), "shards[1] (000002): failed to open database") {
+		t.Errorf("NewClusterFromConfig() error = %v", err)
+	}
+	if _, err := NewClusterFromConfig(nil, &Config{Shards: cfg.Shards[:1]}); err == nil {
+		t.Error("NewClusterFromConfig() error = nil without generator")
+	}
+}
+
+func TestDuration_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Duration
+		wantErr bool
+	}{
+		{"string", `"1m30s"`, Duration(90 * time.Second), false},
+		{"number", `1000`, Duration(1000), false},
+		{"bad string", `"soon"`, 0, true},
+		{"bool", `true`, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d Duration
+			if err := d.UnmarshalJSON([]byte(tt.json)); (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if d != tt.want {
+				t.Errorf("UnmarshalJSON() = %v, want %v", d, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// NewCoordinator returns a Coordinator running two-phase commits over the
+// primary databases of c. The node name prefixes the transaction IDs and
+// must be unique among the processes sharing the databases: Recover only
+// resolves the transactions of its own node.
+func NewCoordinator(c Cluster, dialect XADialect, log DecisionLog, node string) (Coordinator, error) {
+	if c == nil || dialect == nil || log == nil {
+		return nil, cErr("cluster, dialect and log cannot be nil")
+	}
+	if !xidPattern.MatchString(node) {
+		return nil, cErr("invalid node name '" + node + "'")
+	}
+	return &coordinator{c, dialect, log, "c2pc_" + node + "_"}, nil
+}
+
+// Coordinator interface.
+type Coordinator interface {
+	// Run executes fn for every Shard the IDs belong to, each on its own
+	// connection, and commits all the branches or none of them. It returns
+	// ErrInDoubt if the decision could not be recorded and ErrNotApplied if
+	// it was recorded but some branches could not be committed; Recover
+	// settles both cases.
+	Run(ctx context.Context, ids []string, fn BranchFunc) error
+
+	// Recover resolves the prepared branches left behind by a crash or by a
+	// failed Run: branches with a recorded decision are committed, the
+	// others are rolled back. A decision is forgotten once every Shard it
+	// was recorded for is settled; decisions involving a Shard that failed
+	// or is not in the Cluster are kept for the next Recover. It must not
+	// run concurrently with Run on the same node.
+	Recover(ctx context.Context) error
+}
+
+// BranchFunc runs the work of a distributed transaction on a single Shard.
+// All statements must go through conn.
+type BranchFunc func(ctx context.Context, s Shard, conn *sql.Conn, ids []string) error
+
+var (
+	xidPattern = regexp.MustCompile("^[a-zA-Z0-9_.-]+$")
+)
+
+type coordinator struct {
+	c       Cluster
+	dialect XADialect
+	log     DecisionLog
+	prefix  string
+}
+
+type branch struct {
+	s        Shard
+	xid      string
+	conn     *sql.Conn
+	prepared bool
+}
+
+func (co *coordinator) Run(ctx context.Context, ids []string, fn BranchFunc) error {
+	groups, err := co.c.ManyContext(ctx, ids...)
+	if err != nil {
+		return err
+	}
+	gid, err := newGid()
+	if err != nil {
+		return err
+	}
+	var branches []*branch
+	defer func() {
+		for _, b := range branches {
+			_ = b.conn.Close()
+		}
+	}()
+	shards := make([]Shard, 0, len(groups))
+	for s := range groups {
+		shards = append(shards, s)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].ID() < shards[j].ID() })
+	if err := co.current(shards); err != nil {
+		return err
+	}
+	for _, s := range shards {
+		b := &branch{s: s, xid: co.xid(gid, s.ID())}
+		if !xidPattern.MatchString(b.xid) {
+			co.abort(branches)
+			return &ShardError{s, cErr("shard id cannot be used in a transaction id")}
+		}
+		if b.conn, err = s.Writer().Conn(ctx); err != nil {
+			co.abort(branches)
+			return &ShardError{s, err}
+		}
+		branches = append(branches, b)
+		if err := co.prepare(ctx, b, groups[s], fn); err != nil {
+			co.abort(branches)
+			return &ShardError{s, err}
+		}
+	}
+	if err := co.current(shards); err != nil {
+		co.abort(branches)
+		return err
+	}
+	shardIds := make([]string, len(branches))
+	for i, b := range branches {
+		shardIds[i] = b.s.ID()
+	}
+	if err := co.log.Commit(ctx, gid, shardIds); err != nil {
+		return ErrInDoubt
+	}
+	failed := false
+	for _, b := range branches {
+		if err := co.dialect.CommitPrepared(ctx, b.conn, b.xid); err != nil {
+			failed = true
+		}
+	}
+	if failed {
+		return ErrNotApplied
+	}
+	_ = co.log.Forget(ctx, gid)
+	return nil
+}
+
+func (co *coordinator) prepare(ctx context.Context, b *branch, ids []string, fn BranchFunc) error {
+	if err := co.dialect.Begin(ctx, b.conn, b.xid); err != nil {
+		return err
+	}
+	if err := fn(ctx, b.s, b.conn, ids); err != nil {
+		_ = co.dialect.Abort(ctx, b.conn, b.xid)
+		return err
+	}
+	if err := co.dialect.Prepare(ctx, b.conn, b.xid); err != nil {
+		_ = co.dialect.Abort(ctx, b.conn, b.xid)
+		return err
+	}
+	b.prepared = true
+	return nil
+}
+
+// current fails if one of the Shards was removed from the Cluster or
+// replaced, e.g. by a Watcher, since the IDs were grouped: its branch would
+// not run on the database the IDs belong to anymore.
+func (co *coordinator) current(shards []Shard) error {
+	all := co.c.All()
+	ids := make(map[string]Shard, len(all))
+	for _, s := range all {
+		ids[s.ID()] = s
+	}
+	for _, s := range shards {
+		if ids[s.ID()] != s {
+			return &ShardError{s, cErr("shard was removed or replaced")}
+		}
+	}
+	return nil
+}
+
+// abort rolls back the prepared branches. Branches that failed before
+// being prepared were already aborted by prepare. A branch that cannot be
+// rolled back stays prepared without a decision and is rolled back by
+// Recover.
+func (co *coordinator) abort(branches []*branch) {
+	ctx := context.Background()
+	for _, b := range branches {
+		if b.prepared {
+			_ = co.dialect.RollbackPrepared(ctx, b.conn, b.xid)
+		}
+	}
+}
+
+func (co *coordinator) Recover(ctx context.Context) error {
+	res := co.c.Scatter(ctx, ScatterOptions{CollectAll: true}, func(ctx context.Context, s Shard) error {
+		xids, err := co.dialect.Recover(ctx, s.Writer())
+		if err != nil {
+			return err
+		}
+		for _, xid := range xids {
+			gid, ok := co.gid(xid)
+			if !ok {
+				continue
+			}
+			committed, err := co.log.Committed(ctx, gid)
+			if err != nil {
+				return err
+			}
+			if committed {
+				err = co.dialect.CommitPrepared(ctx, s.Writer(), xid)
+			} else {
+				err = co.dialect.RollbackPrepared(ctx, s.Writer(), xid)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	settled := make(map[string]bool, len(res.Succeeded))
+	for _, s := range res.Succeeded {
+		settled[s.ID()] = true
+	}
+	pending, err := co.log.Pending(ctx)
+	if err != nil {
+		return err
+	}
+next:
+	for gid, shards := range pending {
+		for _, id := range shards {
+			if !settled[id] {
+				continue next
+			}
+		}
+		if err := co.log.Forget(ctx, gid); err != nil {
+			return err
+		}
+	}
+	return res.Err()
+}
+
+func (co *coordinator) xid(gid, shardId string) string {
+	return co.prefix + gid + "." + shardId
+}
+
+// gid returns the transaction ID of xid, and false if xid does not belong
+// to this node.
+func (co *coordinator) gid(xid string) (string, bool) {
+	if !strings.HasPrefix(xid, co.prefix) {
+		return "", false
+	}
+	rest := xid[len(co.prefix):]
+	i := strings.IndexByte(rest, '.')
+	if i == -1 {
+		return "", false
+	}
+	return rest[:i], true
+}
+
+func newGid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", wrapErr(err, "failed to generate transaction id")
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,379 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var gidPattern = regexp.MustCompile("[0-9a-f]{32}")
+
+// xaStatements returns the recorded statements with the random transaction
+// ID replaced by GID.
+func xaStatements(f *fakeDB) []string {
+	res := f.statements()
+	for i, s := range res {
+		res[i] = gidPattern.ReplaceAllString(s, "GID")
+	}
+	return res
+}
+
+// failOn makes f fail every statement starting with prefix.
+func failOn(f *fakeDB, prefix string) {
+	f.exec = func(query string, _ []driver.NamedValue) (driver.Result, error) {
+		if strings.HasPrefix(query, prefix) {
+			return nil, errors.New("boom")
+		}
+		return driver.RowsAffected(1), nil
+	}
+}
+
+func newTestDecisionLog(t *testing.T) DecisionLog {
+	t.Helper()
+	l, err := NewFileDecisionLog(filepath.Join(t.TempDir(), "decisions.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func transfer(ctx context.Context, s Shard, conn *sql.Conn, ids []string) error {
+	_, err := conn.ExecContext(ctx, "UPDATE accounts")
+	return err
+}
+
+func Test_coordinator_Run(t *testing.T) {
+	const (
+		xid1 = "'c2pc_n1_GID.000001'"
+		xid2 = "'c2pc_n1_GID.000002'"
+	)
+	tests := []struct {
+		name        string
+		failOn      [2]string
+		wantErr     error
+		wantPending int
+		want        [2][]string
+	}{
+		{
+			"commit",
+			[2]string{},
+			nil,
+			0,
+			[2][]string{
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid1, "COMMIT PREPARED " + xid1},
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid2, "COMMIT PREPARED " + xid2},
+			},
+		},
+		{
+			"branch failure",
+			[2]string{"", "UPDATE"},
+			errors.New("boom"),
+			0,
+			[2][]string{
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid1, "ROLLBACK PREPARED " + xid1},
+				{"BEGIN", "UPDATE accounts", "ROLLBACK"},
+			},
+		},
+		{
+			"prepare failure",
+			[2]string{"", "PREPARE"},
+			errors.New("boom"),
+			0,
+			[2][]string{
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid1, "ROLLBACK PREPARED " + xid1},
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid2, "ROLLBACK"},
+			},
+		},
+		{
+			"commit failure",
+			[2]string{"", "COMMIT"},
+			ErrNotApplied,
+			1,
+			[2][]string{
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid1, "COMMIT PREPARED " + xid1},
+				{"BEGIN", "UPDATE accounts", "PREPARE TRANSACTION " + xid2, "COMMIT PREPARED " + xid2},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, c := newFakeCluster(t, "xa-run-"+tt.name, 2)
+			for i, prefix := range tt.failOn {
+				if prefix != "" {
+					failOn(fs[i], prefix)
+				}
+			}
+			log := newTestDecisionLog(t)
+			co, err := NewCoordinator(c, PostgresXA, log, "n1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = co.Run(context.Background(), []string{"1@000002", "2@000001"}, transfer)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr.Error())) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			for i, f := range fs {
+				if got := xaStatements(f); !reflect.DeepEqual(got, tt.want[i]) {
+					t.Errorf("Run() statements on shard %d = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+			if pending, _ := log.Pending(context.Background()); len(pending) != tt.wantPending {
+				t.Errorf("Pending() = %v, want %d decisions", pending, tt.wantPending)
+			}
+		})
+	}
+}
+
+// swappingCluster runs swap right after grouping IDs.
+type swappingCluster struct {
+	Cluster
+	swap func()
+}
+
+func (c *swappingCluster) ManyContext(ctx context.Context, ids ...string) (map[Shard][]string, error) {
+	groups, err := c.Cluster.ManyContext(ctx, ids...)
+	c.swap()
+	return groups, err
+}
+
+func Test_coordinator_Run_replaced(t *testing.T) {
+	fs, c := newFakeCluster(t, "xa-run-replaced", 2)
+	_, db := newFakeDB("xa-run-replaced-2b")
+	sc := &swappingCluster{c, func() {
+		if _, err := c.ReplaceShard(NewShard("000002", db, false)); err != nil {
+			t.Fatal(err)
+		}
+	}}
+	log := newTestDecisionLog(t)
+	co, err := NewCoordinator(sc, PostgresXA, log, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Run(context.Background(), []string{"1@000002", "2@000001"}, transfer); err == nil {
+		t.Error("Run() error = nil after a shard was replaced")
+	}
+	for i, f := range fs {
+		if got := xaStatements(f); len(got) != 0 {
+			t.Errorf("Run() statements on shard %d = %v, want none", i+1, got)
+		}
+	}
+	if pending, _ := log.Pending(context.Background()); len(pending) != 0 {
+		t.Errorf("Pending() = %v, want no decision", pending)
+	}
+}
+
+func Test_coordinator_Recover(t *testing.T) {
+	fs, c := newFakeCluster(t, "xa-recover", 2)
+	log := newTestDecisionLog(t)
+	co, err := NewCoordinator(c, PostgresXA, log, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := log.Commit(ctx, "aaaa", []string{"000001", "000002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Commit(ctx, "cccc", []string{"000001"}); err != nil {
+		t.Fatal(err)
+	}
+	prepared := [][]driver.Value{
+		{"c2pc_n1_aaaa.000002"},
+		{"c2pc_n1_bbbb.000002"},
+		{"c2pc_n2_aaaa.000002"},
+		{"foreign"},
+	}
+	fs[1].query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		return newFakeRows("gid", prepared...), nil
+	}
+	if err := co.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"SELECT gid FROM pg_prepared_xacts WHERE database = current_database()",
+		"COMMIT PREPARED 'c2pc_n1_aaaa.000002'",
+		"ROLLBACK PREPARED 'c2pc_n1_bbbb.000002'",
+	}
+	if got := fs[1].statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Recover() statements = %v, want %v", got, want)
+	}
+	if pending, _ := log.Pending(ctx); len(pending) != 0 {
+		t.Errorf("Pending() = %v after recovery", pending)
+	}
+}
+
+func Test_coordinator_Recover_failure(t *testing.T) {
+	fs, c := newFakeCluster(t, "xa-recover-failure", 2)
+	log := newTestDecisionLog(t)
+	co, err := NewCoordinator(c, MySQLXA, log, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := log.Commit(ctx, "aaaa", []string{"000001"}); err != nil {
+		t.Fatal(err)
+	}
+	fs[0].query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		return newFakeRows("formatID,gtrid_length,bqual_length,data",
+			[]driver.Value{int64(1), int64(19), int64(0), "c2pc_n1_aaaa.000001"}), nil
+	}
+	failOn(fs[0], "XA COMMIT")
+	if err := co.Recover(ctx); err == nil {
+		t.Error("Recover() error = nil")
+	}
+	if pending, _ := log.Pending(ctx); !reflect.DeepEqual(pending, map[string][]string{"aaaa": {"000001"}}) {
+		t.Errorf("Pending() = %v, want the unresolved decision", pending)
+	}
+}
+
+func Test_coordinator_Recover_missingShard(t *testing.T) {
+	_, c := newFakeCluster(t, "xa-recover-missing", 2)
+	log := newTestDecisionLog(t)
+	co, err := NewCoordinator(c, PostgresXA, log, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := log.Commit(ctx, "aaaa", []string{"000001", "000003"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := log.Commit(ctx, "bbbb", []string{"000001", "000002"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Recover(ctx); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"aaaa": {"000001", "000003"}}
+	if pending, _ := log.Pending(ctx); !reflect.DeepEqual(pending, want) {
+		t.Errorf("Pending() = %v, want %v", pending, want)
+	}
+}
+
+func TestMySQLXA(t *testing.T) {
+	fs, c := newFakeCluster(t, "xa-mysql", 2)
+	failOn(fs[1], "XA PREPARE")
+	co, err := NewCoordinator(c, MySQLXA, newTestDecisionLog(t), "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := co.Run(context.Background(), []string{"1@000001", "1@000002"}, transfer); err == nil {
+		t.Error("Run() error = nil")
+	}
+	want := [][]string{
+		{
+			"XA START 'c2pc_n1_GID.000001'",
+			"UPDATE accounts",
+			"XA END 'c2pc_n1_GID.000001'",
+			"XA PREPARE 'c2pc_n1_GID.000001'",
+			"XA ROLLBACK 'c2pc_n1_GID.000001'",
+		},
+		{
+			"XA START 'c2pc_n1_GID.000002'",
+			"UPDATE accounts",
+			"XA END 'c2pc_n1_GID.000002'",
+			"XA PREPARE 'c2pc_n1_GID.000002'",
+			"XA END 'c2pc_n1_GID.000002'",
+			"XA ROLLBACK 'c2pc_n1_GID.000002'",
+		},
+	}
+	for i, f := range fs {
+		if got := xaStatements(f); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("statements on shard %d = %v, want %v", i+1, got, want[i])
+		}
+	}
+}
+
+func TestNewCoordinator(t *testing.T) {
+	_, c := newFakeCluster(t, "xa-new", 2)
+	log := newTestDecisionLog(t)
+	tests := []struct {
+		name    string
+		c       Cluster
+		node    string
+		wantErr bool
+	}{
+		{"ok", c, "node-1", false},
+		{"no cluster", nil, "node-1", true},
+		{"bad node", c, "node 1", true},
+		{"empty node", c, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewCoordinator(tt.c, PostgresXA, log, tt.node); (err != nil) != tt.wantErr {
+				t.Errorf("NewCoordinator() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewFileDecisionLog(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	l, err := NewFileDecisionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gid := range []string{"a", "b", "c"} {
+		if err := l.Commit(ctx, gid, []string{"000001"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Forget(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"op":"forget","gi`); err != nil {
+		t.Fatal(err)
+	}
+	_ = f.Close()
+	l, err = NewFileDecisionLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"a": {"000001"}, "c": {"000001"}}
+	if pending, _ := l.Pending(ctx); !reflect.DeepEqual(pending, want) {
+		t.Errorf("Pending() = %v, want %v", pending, want)
+	}
+	if ok, _ := l.Committed(ctx, "a"); !ok {
+		t.Error("Committed() = false for a")
+	}
+	if ok, _ := l.Committed(ctx, "b"); ok {
+		t.Error("Committed() = true for forgotten b")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 2 {
+		t.Errorf("compacted log has %d records, want 2", got)
+	}
+}
+
+func TestNewFileDecisionLog_corrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions.log")
+	log := `{"op":"commit","gid":"a","shards":["000001"]}` + "\n" +
+		`{"op":"forget","gi` + "\n" +
+		`{"op":"commit","gid":"b","shards":["000001"]}` + "\n"
+	if err := os.WriteFile(path, []byte(log), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileDecisionLog(path); err == nil {
+		t.Error("NewFileDecisionLog() error = nil for a corrupt record")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != log {
+		t.Error("NewFileDecisionLog() rewrote a corrupt log")
+	}
+}
@@ -0,0 +1,95 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// Cursor holds the last seen sort key of every Shard of a paginated cross
+// shard listing, by Shard ID. A Shard missing from the Cursor is read from
+// the start, so Shards added between two pages are not skipped.
+type Cursor map[string]string
+
+// After returns the sort key to resume shardId after, and false if the
+// Shard has to be read from the start.
+func (c Cursor) After(shardId string) (string, bool) {
+	k, exists := c[shardId]
+	return k, exists
+}
+
+// Set records key as the last seen sort key of shardId. When paging over
+// MergeRows, the Shard of the current row is given by Merger.Source.
+func (c Cursor) Set(shardId string, key string) {
+	c[shardId] = key
+}
+
+// NewCursorCodec returns a CursorCodec that signs cursors with key using
+// HMAC-SHA256. The key must not be empty.
+func NewCursorCodec(key []byte) (CursorCodec, error) {
+	if len(key) == 0 {
+		return nil, cErr("key is empty")
+	}
+	k := make([]byte, len(key))
+	copy(k, key)
+	return &cursorCodec{k}, nil
+}
+
+// CursorCodec interface.
+type CursorCodec interface {
+	// Encode returns the opaque, URL safe form of a Cursor. An empty
+	// Cursor is encoded as an empty string.
+	Encode(Cursor) (string, error)
+
+	// Decode verifies and decodes an opaque Cursor. An empty string decodes
+	// to an empty Cursor; anything else that was not produced by Encode
+	// with the same key fails with ErrInvalidCursor.
+	Decode(string) (Cursor, error)
+}
+
+type cursorCodec struct {
+	key []byte
+}
+
+func (cc *cursorCodec) Encode(c Cursor) (string, error) {
+	if len(c) == 0 {
+		return "", nil
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", wrapErr(err, "failed to encode cursor")
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(cc.sign(payload)), nil
+}
+
+func (cc *cursorCodec) Decode(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	i := strings.IndexByte(s, '.')
+	if i == -1 {
+		return nil, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(s[:i])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(s[i+1:])
+	if err != nil || !hmac.Equal(mac, cc.sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	c := Cursor{}
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func (cc *cursorCodec) sign(payload []byte) []byte {
+	h := hmac.New(sha256.New, cc.key)
+	h.Write(payload)
+	return h.Sum(nil)
+}
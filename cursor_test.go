@@ -0,0 +1,81 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestCursorCodec(t *testing.T, key string) CursorCodec {
+	t.Helper()
+	cc, err := NewCursorCodec([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cc
+}
+
+func TestNewCursorCodec(t *testing.T) {
+	for _, key := range [][]byte{nil, {}} {
+		if _, err := NewCursorCodec(key); err == nil {
+			t.Errorf("NewCursorCodec(%q) error = nil", key)
+		}
+	}
+}
+
+func Test_cursorCodec(t *testing.T) {
+	cc := newTestCursorCodec(t, "secret")
+	c := Cursor{}
+	c.Set("000001", "2021-10-01T10:00:00Z|42")
+	c.Set("000002", "")
+	enc, err := cc.Encode(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cc.Decode(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, c) {
+		t.Errorf("Decode() = %v, want %v", got, c)
+	}
+	if k, ok := got.After("000001"); !ok || k != "2021-10-01T10:00:00Z|42" {
+		t.Errorf("After() = %v, %v", k, ok)
+	}
+	if _, ok := got.After("000003"); ok {
+		t.Error("After() = true for unknown shard")
+	}
+	tampered := []byte(enc)
+	tampered[3] ^= 1
+	tests := []struct {
+		name    string
+		cc      CursorCodec
+		s       string
+		want    Cursor
+		wantErr bool
+	}{
+		{"ok", cc, enc, c, false},
+		{"empty", cc, "", Cursor{}, false},
+		{"tampered", cc, string(tampered), nil, true},
+		{"other key", newTestCursorCodec(t, "other"), enc, nil, true},
+		{"no signature", cc, enc[:len(enc)-44], nil, true},
+		{"garbage", cc, "!!!.!!!", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.cc.Decode(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Decode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil && err != ErrInvalidCursor {
+				t.Errorf("Decode() error = %v, want %v", err, ErrInvalidCursor)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if enc, err := cc.Encode(Cursor{}); err != nil || enc != "" {
+		t.Errorf("Encode() = %q, %v, want empty", enc, err)
+	}
+}
@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DecisionLog durably records the commit decisions of a Coordinator.
+// Recording a decision is the commit point of a distributed transaction:
+// branches of a transaction without a decision are rolled back on
+// recovery.
+type DecisionLog interface {
+	// Commit durably records that transaction gid is committed on the
+	// given Shards.
+	Commit(ctx context.Context, gid string, shards []string) error
+
+	// Committed reports whether a commit decision exists for gid.
+	Committed(ctx context.Context, gid string) (bool, error)
+
+	// Forget removes the decision for gid once all its branches are
+	// committed.
+	Forget(ctx context.Context, gid string) error
+
+	// Pending returns the transactions with a decision that was not
+	// forgotten yet, with the Shards they were committed on.
+	Pending(ctx context.Context) (map[string][]string, error)
+}
+
+// NewFileDecisionLog returns a DecisionLog appending to the file at path,
+// which is created if needed. Every record is synced to disk before it is
+// acknowledged. Forgotten decisions are dropped from the file when it is
+// opened.
+func NewFileDecisionLog(path string) (DecisionLog, error) {
+	l := &fileDecisionLog{path: path, pending: make(map[string][]string)}
+	if err := l.load(); err != nil {
+		return nil, wrapErr(err, "failed to load decision log")
+	}
+	if err := l.compact(); err != nil {
+		return nil, wrapErr(err, "failed to compact decision log")
+	}
+	return l, nil
+}
+
+type decisionRecord struct {
+	Op     string   `json:"op"`
+	Gid    string   `json:"gid"`
+	Shards []string `json:"shards,omitempty"`
+}
+
+type fileDecisionLog struct {
+	path    string
+	f       *os.File
+	pending map[string][]string
+	mu      sync.Mutex
+}
+
+func (l *fileDecisionLog) Commit(_ context.Context, gid string, shards []string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.append(decisionRecord{"commit", gid, shards}); err != nil {
+		return err
+	}
+	l.pending[gid] = shards
+	return nil
+}
+
+func (l *fileDecisionLog) Committed(_ context.Context, gid string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, exists := l.pending[gid]
+	return exists, nil
+}
+
+func (l *fileDecisionLog) Forget(_ context.Context, gid string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, exists := l.pending[gid]; !exists {
+		return nil
+	}
+	if err := l.append(decisionRecord{Op: "forget", Gid: gid}); err != nil {
+		return err
+	}
+	delete(l.pending, gid)
+	return nil
+}
+
+func (l *fileDecisionLog) Pending(_ context.Context) (map[string][]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make(map[string][]string, len(l.pending))
+	for gid, shards := range l.pending {
+		res[gid] = append([]string(nil), shards...)
+	}
+	return res, nil
+}
+
+func (l *fileDecisionLog) append(r decisionRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+// load replays the log. A torn last line, left by a crash in the middle of
+// a write, was never acknowledged and is ignored; any other corrupt line
+// fails the load, as skipping it could lose a decision.
+func (l *fileDecisionLog) load() error {
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	var torn error
+	for line := 1; sc.Scan(); line++ {
+		if torn != nil {
+			return torn
+		}
+		var r decisionRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			torn = wrapErr(err, "corrupt record on line "+strconv.Itoa(line))
+			continue
+		}
+		switch r.Op {
+		case "commit":
+			l.pending[r.Gid] = r.Shards
+		case "forget":
+			delete(l.pending, r.Gid)
+		}
+	}
+	return sc.Err()
+}
+
+// compact writes the pending decisions to a new file and atomically
+// replaces the log with it.
+func (l *fileDecisionLog) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return err
+	}
+	l.f = tmp
+	gids := make([]string, 0, len(l.pending))
+	for gid := range l.pending {
+		gids = append(gids, gid)
+	}
+	sort.Strings(gids)
+	for _, gid := range gids {
+		if err := l.append(decisionRecord{"commit", gid, l.pending[gid]}); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	syncDir(filepath.Dir(l.path))
+	return nil
+}
+
+// syncDir makes a rename durable. It is best effort, as directories cannot
+// be synced on every platform.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
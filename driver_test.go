@@ -0,0 +1,233 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDriverName is the name the fake driver is registered with.
+const fakeDriverName = "cluster-fake"
+
+var (
+	fakeDBs   = make(map[string]*fakeDB)
+	fakeDBsMu sync.Mutex
+)
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+// newFakeDB registers a fake database under dsn and returns it together
+// with a *sql.DB connected to it.
+func newFakeDB(dsn string) (*fakeDB, *sql.DB) {
+	f := &fakeDB{}
+	fakeDBsMu.Lock()
+	fakeDBs[dsn] = f
+	fakeDBsMu.Unlock()
+	db, err := sql.Open(fakeDriverName, dsn)
+	if err != nil {
+		panic(err)
+	}
+	return f, db
+}
+
+// newFakeCluster returns a Cluster of n writable Shards, 000001 to 00000n,
+// backed by fake databases registered as name-1 to name-n.
+func newFakeCluster(t *testing.T, name string, n int) ([]*fakeDB, Cluster) {
+	t.Helper()
+	fs := make([]*fakeDB, n)
+	shards := make([]Shard, n)
+	for i := range shards {
+		var db *sql.DB
+		fs[i], db = newFakeDB(name + "-" + strconv.Itoa(i+1))
+		shards[i] = NewShard(fmt.Sprintf("%06d", i+1), db, false)
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, c
+}
+
+// fakeDB records every statement it receives and answers them with the
+// configured handlers.
+type fakeDB struct {
+	mu      sync.Mutex
+	log     []string
+	pingErr error
+	commit  func() error
+	exec    func(query string, args []driver.NamedValue) (driver.Result, error)
+	query   func(query string, args []driver.NamedValue) (driver.Rows, error)
+}
+
+func (f *fakeDB) record(stmt string) {
+	f.mu.Lock()
+	f.log = append(f.log, stmt)
+	f.mu.Unlock()
+}
+
+// statements returns a copy of the recorded statements.
+func (f *fakeDB) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	res := make([]string, len(f.log))
+	copy(res, f.log)
+	return res
+}
+
+func (f *fakeDB) setPingErr(err error) {
+	f.mu.Lock()
+	f.pingErr = err
+	f.mu.Unlock()
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeDBsMu.Lock()
+	defer fakeDBsMu.Unlock()
+	f, exists := fakeDBs[dsn]
+	if !exists {
+		f = &fakeDB{}
+		fakeDBs[dsn] = f
+	}
+	return &fakeConn{f}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{c, query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(_ context.Context, _ driver.TxOptions) (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return &fakeTx{c}, nil
+}
+
+func (c *fakeConn) Ping(_ context.Context) error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	return c.db.pingErr
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query)
+	c.db.mu.Lock()
+	exec := c.db.exec
+	c.db.mu.Unlock()
+	if exec == nil {
+		return driver.RowsAffected(0), nil
+	}
+	return exec(query, args)
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query)
+	c.db.mu.Lock()
+	q := c.db.query
+	c.db.mu.Unlock()
+	if q == nil {
+		return &fakeRows{}, nil
+	}
+	return q(query, args)
+}
+
+type fakeTx struct {
+	c *fakeConn
+}
+
+func (tx *fakeTx) Commit() error {
+	tx.c.db.record("COMMIT")
+	tx.c.db.mu.Lock()
+	commit := tx.c.db.commit
+	tx.c.db.mu.Unlock()
+	if commit == nil {
+		return nil
+	}
+	return commit()
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.c.db.record("ROLLBACK")
+	return nil
+}
+
+type fakeStmt struct {
+	c     *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	res := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		res[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return res
+}
+
+// fakeRows is an in-memory result set. If err is set, it is returned
+// instead of io.EOF after the last row.
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	i    int
+	err  error
+}
+
+func newFakeRows(cols string, rows ...[]driver.Value) *fakeRows {
+	return &fakeRows{cols: strings.Split(cols, ","), rows: rows}
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.rows) {
+		if r.err != nil {
+			return r.err
+		}
+		return io.EOF
+	}
+	copy(dest, r.rows[r.i])
+	r.i++
+	return nil
+}
@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// NewHealthMonitor returns a HealthMonitor that probes the primary and
+// replica databases of every Shard of c each interval. A database is
+// marked unhealthy after threshold consecutive failed probes and healthy
+// again after the first successful one. If probe is nil, PingProbe is used.
+// A non-positive interval defaults to 10 seconds. A zero timeout means
+// probes are only bounded by the monitor context.
+func NewHealthMonitor(c Cluster, probe Probe, interval, timeout time.Duration, threshold int) HealthMonitor {
+	if probe == nil {
+		probe = PingProbe
+	}
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &healthMonitor{
+		c:         c,
+		probe:     probe,
+		interval:  interval,
+		timeout:   timeout,
+		threshold: threshold,
+		fails:     make(map[*sql.DB]int),
+	}
+}
+
+// HealthMonitor interface.
+type HealthMonitor interface {
+	// Check probes every database once and updates the health of the
+	// Shards and their replicas.
+	Check(ctx context.Context)
+
+	// Run calls Check every interval until ctx is done.
+	Run(ctx context.Context)
+}
+
+// Probe checks a database connection.
+type Probe func(context.Context, *sql.DB) error
+
+// PingProbe checks a database connection with PingContext.
+func PingProbe(ctx context.Context, db *sql.DB) error {
+	return db.PingContext(ctx)
+}
+
+// QueryProbe returns a Probe that runs query and discards its result.
+func QueryProbe(query string) Probe {
+	return func(ctx context.Context, db *sql.DB) error {
+		rows, err := db.QueryContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		return rows.Close()
+	}
+}
+
+type healthMonitor struct {
+	c         Cluster
+	probe     Probe
+	interval  time.Duration
+	timeout   time.Duration
+	threshold int
+	fails     map[*sql.DB]int
+	mu        sync.Mutex
+}
+
+func (m *healthMonitor) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		m.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Check probes all databases concurrently and then applies the results,
+// dropping the failure counters of databases that left the Cluster. A
+// round interrupted by ctx is discarded.
+func (m *healthMonitor) Check(ctx context.Context) {
+	type target struct {
+		s       Shard
+		db      *sql.DB
+		primary bool
+		err     error
+	}
+	var targets []*target
+	for _, s := range m.c.All() {
+		targets = append(targets, &target{s: s, db: s.Writer(), primary: true})
+		for _, r := range s.Replicas() {
+			targets = append(targets, &target{s: s, db: r})
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(targets))
+	for _, t := range targets {
+		go func(t *target) {
+			defer wg.Done()
+			t.err = m.check(ctx, t.db)
+		}(t)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fails := make(map[*sql.DB]int, len(targets))
+	for _, t := range targets {
+		n := 0
+		if t.err != nil {
+			n = m.fails[t.db] + 1
+		}
+		fails[t.db] = n
+		healthy := n < m.threshold
+		if t.primary {
+			t.s.SetHealthy(healthy)
+		} else {
+			t.s.SetReplicaHealthy(t.db, healthy)
+		}
+	}
+	m.fails = fails
+}
+
+func (m *healthMonitor) check(ctx context.Context, db *sql.DB) error {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	return m.probe(ctx, db)
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_healthMonitor_Check(t *testing.T) {
+	f1, db1 := newFakeDB("health-one")
+	f2, db2 := newFakeDB("health-two")
+	fr, dbr := newFakeDB("health-two-replica")
+	shards := []Shard{
+		NewShard("000001", db1, false),
+		NewReplicatedShard("000002", db2, []*sql.DB{dbr}, nil, false),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, NewRoundRobinPlacer(), shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewHealthMonitor(c, nil, time.Hour, time.Second, 2)
+	down := errors.New("down")
+	tests := []struct {
+		name        string
+		errs        []error
+		wantHealthy []bool
+		wantReader  *sql.DB
+	}{
+		{"all up", []error{nil, nil, nil}, []bool{true, true}, dbr},
+		{"first failure", []error{down, nil, down}, []bool{true, true}, dbr},
+		{"second failure", []error{down, nil, down}, []bool{false, true}, db2},
+		{"recovered", []error{nil, nil, nil}, []bool{true, true}, dbr},
+		{"failure after recovery", []error{down, nil, nil}, []bool{true, true}, dbr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, f := range []*fakeDB{f1, f2, fr} {
+				f.setPingErr(tt.errs[i])
+			}
+			m.Check(context.Background())
+			for i, want := range tt.wantHealthy {
+				if got := shards[i].Healthy(); got != want {
+					t.Errorf("Healthy() shard %d = %v, want %v", i, got, want)
+				}
+			}
+			if got := shards[1].Reader(); got != tt.wantReader {
+				t.Errorf("Reader() = %p, want %p", got, tt.wantReader)
+			}
+		})
+	}
+}
+
+func Test_cluster_Next_unhealthy(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+	}
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shards[0].SetHealthy(false)
+	for i := 0; i < 3; i++ {
+		if _, got, err := c.Next(); err != nil || got != shards[1] {
+			t.Errorf("Next() got = %v, err = %v, want %v", got, err, shards[1])
+		}
+	}
+	shards[1].SetHealthy(false)
+	if _, _, err := c.Next(); err != ErrNoWritableShard {
+		t.Errorf("Next() error = %v, want %v", err, ErrNoWritableShard)
+	}
+}
+
+func TestQueryProbe(t *testing.T) {
+	f, db := newFakeDB("health-query")
+	probe := QueryProbe("SELECT 1")
+	if err := probe(context.Background(), db); err != nil {
+		t.Errorf("QueryProbe() error = %v", err)
+	}
+	f.query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		return nil, errors.New("down")
+	}
+	if err := probe(context.Background(), db); err == nil {
+		t.Error("QueryProbe() error = nil")
+	}
+	if got := f.statements(); len(got) != 2 || got[0] != "SELECT 1" {
+		t.Errorf("statements = %v", got)
+	}
+}
+
+func TestNewHealthMonitor(t *testing.T) {
+	_, c := newFakeCluster(t, "health-new", 1)
+	m := NewHealthMonitor(c, nil, -time.Second, 0, 0).(*healthMonitor)
+	if m.interval != 10*time.Second || m.threshold != 1 {
+		t.Errorf("interval = %v, threshold = %d, want the defaults", m.interval, m.threshold)
+	}
+}
+
+func Test_healthMonitor_Run(t *testing.T) {
+	f, db := newFakeDB("health-run")
+	s := NewShard("000001", db, false)
+	c, err := NewCluster(testIdGen, defaultCombiner, nil, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.setPingErr(errors.New("down"))
+	var mu sync.Mutex
+	calls := 0
+	m := NewHealthMonitor(c, func(ctx context.Context, db *sql.DB) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return PingProbe(ctx, db)
+	}, time.Millisecond, 0, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for s.Healthy() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if s.Healthy() {
+		t.Error("Healthy() = true after failed probes")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if calls == 0 {
+		t.Error("Run() never probed")
+	}
+}
@@ -1,9 +1,17 @@
 package cluster
 
+import (
+	"context"
+)
+
 const (
 	ErrShardNotFound   = cErr("shard not found")
 	ErrNoWritableShard = cErr("could not find a writable shard")
 	ErrIdParseFailed   = cErr("failed to parse id")
+	ErrInvalidCursor   = cErr("invalid cursor")
+	ErrInDoubt         = cErr("transaction outcome is in doubt")
+	ErrNotApplied      = cErr("transaction committed but not applied on every shard")
+	ErrIdCombineFailed = cErr("failed to combine id")
 
 
 )
@@ -15,6 +23,14 @@ type Generator interface {
 	Generate() string
 }
 
+// GeneratorContext is implemented by Generators that can fail or need to
+// be cancelled, e.g. because they reach a database. When a Generator
+// implements it, Cluster uses GenerateContext instead of Generate.
+type GeneratorContext interface {
+	// GenerateContext generates a new ID.
+	GenerateContext(context.Context) (string, error)
+}
+
 
 
 type cErr string
@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HiLoOptions configure a HiLo generator.
+type HiLoOptions struct {
+	// BlockSize is the number of IDs reserved at once. Defaults to 1000.
+	BlockSize int64
+
+	// Prefetch is the number of IDs left in the current block at which
+	// the next block is reserved in the background. Defaults to a tenth of
+	// the block size; a negative value disables prefetching.
+	Prefetch int64
+
+	// Timeout bounds the reservation of a block. Defaults to 10 seconds.
+	Timeout time.Duration
+}
+
+// NewHiLo returns a HiLo generator reserving blocks of the sequence name
+// from table on the primary database of s. The table is expected to look
+// like:
+//
+//	CREATE TABLE sequences (
+//		name  VARCHAR(255) PRIMARY KEY,
+//		value BIGINT NOT NULL
+//	)
+//
+// where value is the last reserved ID. The row of the sequence must exist;
+// a row with value 0 starts the sequence at 1.
+func NewHiLo(s Shard, table, name string, bind BindStyle, opts HiLoOptions) (HiLo, error) {
+	if s == nil {
+		return nil, cErr("shard cannot be nil")
+	}
+	if err := validTable(table); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, cErr("sequence name is empty")
+	}
+	if opts.BlockSize <= 0 {
+		opts.BlockSize = 1000
+	}
+	if opts.Prefetch == 0 {
+		opts.Prefetch = opts.BlockSize / 10
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	return &hilo{
+		s:      s,
+		name:   name,
+		opts:   opts,
+		update: bind.rebind("UPDATE " + table + " SET value = value + ? WHERE name = ?"),
+		query:  bind.rebind("SELECT value FROM " + table + " WHERE name = ?"),
+	}, nil
+}
+
+// HiLo generates dense numeric IDs from blocks reserved in a database
+// sequence table. Blocks are reserved in a transaction, so generators of
+// the same sequence in different processes never hand out the same ID.
+// IDs of a block that is not used up, e.g. because the process exits, are
+// lost. It is safe for concurrent use.
+type HiLo interface {
+	Generator
+	GeneratorContext
+
+	// NextID returns a new ID. It only waits for the database when the
+	// current block is exhausted and the next one is not reserved yet.
+	NextID(ctx context.Context) (int64, error)
+}
+
+// hiloBlock is a block being reserved. Its fields are set before done is
+// closed.
+type hiloBlock struct {
+	start, end int64
+	err        error
+	done       chan struct{}
+}
+
+type hilo struct {
+	s      Shard
+	name   string
+	opts   HiLoOptions
+	update string
+	query  string
+	cur    int64
+	end    int64
+	ahead  *hiloBlock
+	mu     sync.Mutex
+}
+
+// Generate panics if NextID fails; Cluster uses GenerateContext, which
+// returns the error instead.
+func (g *hilo) Generate() string {
+	id, err := g.GenerateContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (g *hilo) GenerateContext(ctx context.Context) (string, error) {
+	id, err := g.NextID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+// NextID waits for a reservation without holding the lock, so callers
+// giving up and callers of other blocks are never stuck behind it. The
+// first caller to see a reservation complete installs its block.
+func (g *hilo) NextID(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	for g.cur >= g.end {
+		if g.ahead == nil {
+			g.ahead = g.reserve()
+		}
+		b := g.ahead
+		g.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-b.done:
+		}
+		g.mu.Lock()
+		if g.ahead != b {
+			continue
+		}
+		g.ahead = nil
+		if b.err != nil {
+			g.mu.Unlock()
+			return 0, wrapErr(b.err, "failed to reserve ids")
+		}
+		g.cur, g.end = b.start, b.end
+	}
+	id := g.cur
+	g.cur++
+	if g.ahead == nil && g.opts.Prefetch > 0 && g.end-g.cur <= g.opts.Prefetch {
+		g.ahead = g.reserve()
+	}
+	g.mu.Unlock()
+	return id, nil
+}
+
+// reserve reserves the next block in the background. The block is kept
+// until it is needed, even if the caller waiting for it gave up.
+func (g *hilo) reserve() *hiloBlock {
+	b := &hiloBlock{done: make(chan struct{})}
+	go func() {
+		defer close(b.done)
+		ctx, cancel := context.WithTimeout(context.Background(), g.opts.Timeout)
+		defer cancel()
+		var last int64
+		b.err = withTx(ctx, g.s.Writer(), nil, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, g.update, g.opts.BlockSize, g.name)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err == nil && n == 0 {
+				return cErr("sequence '" + g.name + "' not found")
+			}
+			return tx.QueryRowContext(ctx, g.query, g.name).Scan(&last)
+		})
+		b.start, b.end = last-g.opts.BlockSize+1, last+1
+	}()
+	return b
+}
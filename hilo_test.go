@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSequence serves the statements of a HiLo generator from a counter.
+type fakeSequence struct {
+	mu      sync.Mutex
+	value   int64
+	missing bool
+}
+
+func newFakeSequence(dsn string) (*fakeDB, *fakeSequence, Shard) {
+	f, db := newFakeDB(dsn)
+	seq := &fakeSequence{}
+	f.exec = func(_ string, args []driver.NamedValue) (driver.Result, error) {
+		seq.mu.Lock()
+		defer seq.mu.Unlock()
+		if seq.missing {
+			return driver.RowsAffected(0), nil
+		}
+		seq.value += args[0].Value.(int64)
+		return driver.RowsAffected(1), nil
+	}
+	f.query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		seq.mu.Lock()
+		defer seq.mu.Unlock()
+		return newFakeRows("value", []driver.Value{seq.value}), nil
+	}
+	return f, seq, NewShard("000001", db, false)
+}
+
+func Test_hilo_NextID(t *testing.T) {
+	f, seq, s := newFakeSequence("hilo-next")
+	seq.value = 100
+	g, err := NewHiLo(s, "sequences", "users", BindDollar, HiLoOptions{BlockSize: 4, Prefetch: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for want := int64(101); want <= 109; want++ {
+		got, err := g.NextID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("NextID() = %v, want %v", got, want)
+		}
+	}
+	stmts := []string{
+		"BEGIN",
+		"UPDATE sequences SET value = value + $1 WHERE name = $2",
+		"SELECT value FROM sequences WHERE name = $1",
+		"COMMIT",
+	}
+	if got := f.statements(); len(got) != 3*len(stmts) || got[1] != stmts[1] || got[2] != stmts[2] {
+		t.Errorf("NextID() statements = %v, want 3 reservations of %v", got, stmts)
+	}
+}
+
+func Test_hilo_prefetch(t *testing.T) {
+	f, _, s := newFakeSequence("hilo-prefetch")
+	g, err := NewHiLo(s, "sequences", "users", BindQuestion, HiLoOptions{BlockSize: 10, Prefetch: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 7; i++ {
+		if _, err := g.NextID(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(f.statements()); got != 4 {
+		t.Errorf("reserved %d statements before the prefetch threshold, want 4", got)
+	}
+	id, err := g.NextID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 8 {
+		t.Errorf("NextID() = %v, want 8", id)
+	}
+	g.(*hilo).mu.Lock()
+	b := g.(*hilo).ahead
+	g.(*hilo).mu.Unlock()
+	<-b.done
+	if b.start != 11 || b.end != 21 || b.err != nil {
+		t.Errorf("prefetched block = %+v, want [11, 21)", b)
+	}
+	for want := int64(9); want <= 11; want++ {
+		if got, _ := g.NextID(ctx); got != want {
+			t.Errorf("NextID() = %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_hilo_missing(t *testing.T) {
+	f, seq, s := newFakeSequence("hilo-missing")
+	seq.missing = true
+	g, err := NewHiLo(s, "sequences", "users", BindQuestion, HiLoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.(GeneratorContext).GenerateContext(context.Background()); err == nil {
+		t.Error("GenerateContext() error = nil for a missing sequence")
+	}
+	if got := f.statements(); got[len(got)-1] != "ROLLBACK" {
+		t.Errorf("statements = %v, want a rollback", got)
+	}
+	seq.missing = false
+	if id, err := g.(GeneratorContext).GenerateContext(context.Background()); err != nil || id != "1" {
+		t.Errorf("GenerateContext() = %v, %v, want 1 once the sequence exists", id, err)
+	}
+}
+
+func Test_hilo_NextID_waiting(t *testing.T) {
+	f, _, s := newFakeSequence("hilo-waiting")
+	exec := f.exec
+	entered, release := make(chan struct{}), make(chan struct{})
+	f.exec = func(query string, args []driver.NamedValue) (driver.Result, error) {
+		close(entered)
+		<-release
+		return exec(query, args)
+	}
+	g, err := NewHiLo(s, "sequences", "users", BindQuestion, HiLoOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := g.NextID(context.Background())
+		done <- err
+	}()
+	<-entered
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	returned := make(chan error, 1)
+	go func() {
+		_, err := g.NextID(ctx)
+		returned <- err
+	}()
+	select {
+	case err := <-returned:
+		if err != context.Canceled {
+			t.Errorf("NextID() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Error("NextID() blocked behind a pending reservation")
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_hilo_concurrent(t *testing.T) {
+	_, _, s := newFakeSequence("hilo-concurrent")
+	gens := make([]Generator, 3)
+	for i := range gens {
+		g, err := NewHiLo(s, "sequences", "users", BindQuestion, HiLoOptions{BlockSize: 16})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gens[i] = g
+	}
+	const workers, n = 6, 200
+	ids := make(chan string, workers*n)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(g Generator) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				ids <- g.Generate()
+			}
+		}(gens[w%len(gens)])
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[string]struct{}, workers*n)
+	for id := range ids {
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			t.Fatalf("Generate() = %q, not a number", id)
+		}
+		if _, exists := seen[id]; exists {
+			t.Fatalf("Generate() returned %v twice", id)
+		}
+		seen[id] = struct{}{}
+	}
+}
+
+func TestNewHiLo(t *testing.T) {
+	_, _, s := newFakeSequence("hilo-new")
+	tests := []struct {
+		name    string
+		s       Shard
+		table   string
+		seq     string
+		wantErr bool
+	}{
+		{"ok", s, "sequences", "users", false},
+		{"no shard", nil, "sequences", "users", true},
+		{"bad table", s, "sequences;", "users", true},
+		{"no name", s, "sequences", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewHiLo(tt.s, tt.table, tt.seq, BindQuestion, HiLoOptions{}); (err != nil) != tt.wantErr {
+				t.Errorf("NewHiLo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
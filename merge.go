@@ -0,0 +1,229 @@
+package cluster
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"sync"
+)
+
+// ScanFunc reads the current row into a value.
+type ScanFunc func(*sql.Rows) (interface{}, error)
+
+// LessFunc reports whether a must be returned before b.
+type LessFunc func(a, b interface{}) bool
+
+// MergeRows returns a Merger that streams the rows of several result sets,
+// each already sorted by less, as one sorted result. The first offset rows
+// are skipped and at most limit rows are returned; a limit of zero or less
+// means no limit. Only one row per result set is held in memory, so each
+// shard query should be limited to offset+limit rows. Rows that compare
+// equal are returned in the order of their result sets. The result sets
+// are typically opened with QueryShards.
+func MergeRows(rows []*sql.Rows, scan ScanFunc, less LessFunc, offset, limit int) Merger {
+	return &merger{
+		rows:   rows,
+		scan:   scan,
+		h:      &mergeHeap{less: less},
+		offset: offset,
+		limit:  limit,
+	}
+}
+
+// QueryShards runs query concurrently on a reader of every Shard of c and
+// returns the open result sets, ready for MergeRows, with the Shard each
+// one was read from. The queries run with ctx rather than with the
+// context Scatter passes to its function, which is cancelled when Scatter
+// returns and would close the result sets; for the same reason opts.Timeout
+// is not applied, bound ctx instead. If any query fails, the result sets
+// already opened are closed.
+func QueryShards(ctx context.Context, c Cluster, opts ScatterOptions, query string, args ...interface{}) ([]*sql.Rows, []Shard, error) {
+	opts.Timeout = 0
+	var (
+		opened = make(map[Shard]*sql.Rows)
+		mu     sync.Mutex
+	)
+	res := c.Scatter(ctx, opts, func(sctx context.Context, s Shard) error {
+		if err := sctx.Err(); err != nil {
+			return err
+		}
+		r, err := s.Reader().QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		opened[s] = r
+		mu.Unlock()
+		return nil
+	})
+	if err := res.Err(); err != nil {
+		for _, r := range opened {
+			_ = r.Close()
+		}
+		return nil, nil, err
+	}
+	rows := make([]*sql.Rows, len(res.Succeeded))
+	for i, s := range res.Succeeded {
+		rows[i] = opened[s]
+	}
+	return rows, res.Succeeded, nil
+}
+
+// Merger interface.
+type Merger interface {
+	// Next advances to the next row. It returns false when there are no
+	// more rows or an error occurred; Merger is closed at that point.
+	Next() bool
+
+	// Value returns the current row, as produced by the ScanFunc.
+	Value() interface{}
+
+	// Source returns the index of the result set the current row was read
+	// from.
+	Source() int
+
+	// Err returns the error, if any, that was encountered during
+	// iteration.
+	Err() error
+
+	// Close closes all result sets. It is safe to call more than once.
+	Close() error
+}
+
+type merger struct {
+	rows   []*sql.Rows
+	scan   ScanFunc
+	h      *mergeHeap
+	offset int
+	limit  int
+	n      int
+	cur    interface{}
+	src    int
+	err    error
+	init   bool
+	closed bool
+}
+
+func (m *merger) Next() bool {
+	if m.closed {
+		return false
+	}
+	if !m.init {
+		m.init = true
+		for i := range m.rows {
+			if !m.advance(i) {
+				return false
+			}
+		}
+	}
+	for {
+		if m.h.Len() == 0 || (m.limit > 0 && m.n >= m.limit) {
+			_ = m.Close()
+			return false
+		}
+		top := heap.Pop(m.h).(mergeItem)
+		if !m.advance(top.src) {
+			return false
+		}
+		if m.offset > 0 {
+			m.offset--
+			continue
+		}
+		m.cur, m.src = top.v, top.src
+		m.n++
+		return true
+	}
+}
+
+func (m *merger) Value() interface{} {
+	return m.cur
+}
+
+func (m *merger) Source() int {
+	return m.src
+}
+
+func (m *merger) Err() error {
+	return m.err
+}
+
+func (m *merger) Close() error {
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	m.cur = nil
+	var err error
+	for _, r := range m.rows {
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// advance reads the next row of source i into the heap. It returns false
+// and closes the merger on error.
+func (m *merger) advance(i int) bool {
+	r := m.rows[i]
+	if !r.Next() {
+		if err := r.Err(); err != nil {
+			m.fail(err)
+			return false
+		}
+		return true
+	}
+	v, err := m.scan(r)
+	if err != nil {
+		m.fail(err)
+		return false
+	}
+	heap.Push(m.h, mergeItem{v, i})
+	return true
+}
+
+func (m *merger) fail(err error) {
+	m.err = err
+	_ = m.Close()
+}
+
+type mergeItem struct {
+	v   interface{}
+	src int
+}
+
+type mergeHeap struct {
+	items []mergeItem
+	less  LessFunc
+}
+
+func (h *mergeHeap) Len() int {
+	return len(h.items)
+}
+
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.less(a.v, b.v) {
+		return true
+	}
+	if h.less(b.v, a.v) {
+		return false
+	}
+	return a.src < b.src
+}
+
+func (h *mergeHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+}
+
+func (h *mergeHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(mergeItem))
+}
+
+func (h *mergeHeap) Pop() interface{} {
+	n := len(h.items) - 1
+	x := h.items[n]
+	h.items[n] = mergeItem{}
+	h.items = h.items[:n]
+	return x
+}
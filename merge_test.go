@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type mergeRow struct {
+	k int64
+	s string
+}
+
+// openMergeRows returns one result set per source, each with the given
+// sort keys. The second column names the source and the key.
+func openMergeRows(t *testing.T, name string, sources [][]int64, fail error) []*sql.Rows {
+	t.Helper()
+	res := make([]*sql.Rows, len(sources))
+	for i, keys := range sources {
+		src := string(rune('a' + i))
+		f, db := newFakeDB(name + "-" + src)
+		rows := newFakeRows("k,s")
+		for _, k := range keys {
+			rows.rows = append(rows.rows, []driver.Value{k, src})
+		}
+		if i == len(sources)-1 {
+			rows.err = fail
+		}
+		f.query = func(string, []driver.NamedValue) (driver.Rows, error) {
+			return rows, nil
+		}
+		r, err := db.Query("SELECT k, s FROM t ORDER BY k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[i] = r
+	}
+	return res
+}
+
+func scanMergeRow(r *sql.Rows) (interface{}, error) {
+	var v mergeRow
+	err := r.Scan(&v.k, &v.s)
+	return v, err
+}
+
+func lessMergeRow(a, b interface{}) bool {
+	return a.(mergeRow).k < b.(mergeRow).k
+}
+
+func TestMergeRows(t *testing.T) {
+	sources := [][]int64{{1, 4, 7}, {2, 4, 8}, {}, {3, 9}}
+	tests := []struct {
+		name   string
+		offset int
+		limit  int
+		want   []string
+	}{
+		{"all", 0, 0, []string{"1a", "2b", "3d", "4a", "4b", "7a", "8b", "9d"}},
+		{"limit", 0, 3, []string{"1a", "2b", "3d"}},
+		{"offset", 5, 0, []string{"7a", "8b", "9d"}},
+		{"offset and limit", 2, 3, []string{"3d", "4a", "4b"}},
+		{"offset past end", 10, 3, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := MergeRows(openMergeRows(t, "merge-"+tt.name, sources, nil), scanMergeRow, lessMergeRow, tt.offset, tt.limit)
+			got := make([]string, 0)
+			for m.Next() {
+				v := m.Value().(mergeRow)
+				if src := string(rune('a' + m.Source())); src != v.s {
+					t.Errorf("Source() = %v, want %v", src, v.s)
+				}
+				got = append(got, string(rune('0'+v.k))+v.s)
+			}
+			if err := m.Err(); err != nil {
+				t.Errorf("Err() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("MergeRows() = %v, want %v", got, tt.want)
+			}
+			if err := m.Close(); err != nil {
+				t.Errorf("Close() = %v", err)
+			}
+		})
+	}
+}
+
+func TestMergeRows_errors(t *testing.T) {
+	boom := errors.New("boom")
+	m := MergeRows(openMergeRows(t, "merge-rows-err", [][]int64{{1, 2}, {3}}, boom), scanMergeRow, lessMergeRow, 0, 0)
+	n := 0
+	for m.Next() {
+		n++
+	}
+	if !errors.Is(m.Err(), boom) || n != 2 {
+		t.Errorf("Err() = %v after %d rows, want %v after 2 rows", m.Err(), n, boom)
+	}
+	m = MergeRows(openMergeRows(t, "merge-scan-err", [][]int64{{1}, {2}}, nil), func(*sql.Rows) (interface{}, error) {
+		return nil, boom
+	}, lessMergeRow, 0, 0)
+	if m.Next() || !errors.Is(m.Err(), boom) {
+		t.Errorf("Err() = %v, want %v", m.Err(), boom)
+	}
+	if m.Next() {
+		t.Error("Next() = true after error")
+	}
+}
+
+func TestQueryShards(t *testing.T) {
+	fs, c := newFakeCluster(t, "merge-query-shards", 2)
+	for i, keys := range [][]int64{{1, 4}, {2, 3}} {
+		src := string(rune('a' + i))
+		rows := newFakeRows("k,s")
+		for _, k := range keys {
+			rows.rows = append(rows.rows, []driver.Value{k, src})
+		}
+		fs[i].query = func(string, []driver.NamedValue) (driver.Rows, error) {
+			return rows, nil
+		}
+	}
+	ctx := context.Background()
+	rows, shards, err := QueryShards(ctx, c, ScatterOptions{Timeout: time.Second}, "SELECT k, s FROM t ORDER BY k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(shards, c.All()) {
+		t.Errorf("QueryShards() shards = %v, want %v", shards, c.All())
+	}
+	// database/sql closes result sets asynchronously when their context is
+	// cancelled, give it a chance to do so.
+	time.Sleep(10 * time.Millisecond)
+	m := MergeRows(rows, scanMergeRow, lessMergeRow, 0, 0)
+	var got []string
+	for m.Next() {
+		v := m.Value().(mergeRow)
+		got = append(got, string(rune('0'+v.k))+v.s+shards[m.Source()].ID())
+	}
+	if err := m.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	want := []string{"1a000001", "2b000002", "3b000002", "4a000001"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeRows() = %v, want %v", got, want)
+	}
+}
+
+func TestQueryShards_failure(t *testing.T) {
+	fs, c := newFakeCluster(t, "merge-query-shards-failure", 2)
+	boom := errors.New("boom")
+	fs[1].query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		return nil, boom
+	}
+	rows, shards, err := QueryShards(context.Background(), c, ScatterOptions{CollectAll: true}, "SELECT k, s FROM t")
+	if !errors.Is(err, boom) || rows != nil || shards != nil {
+		t.Errorf("QueryShards() = %v, %v, %v, want %v", rows, shards, err, boom)
+	}
+}
@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NewNumericCombiner returns a NumericCombiner reserving the lowest bits
+// of int64 IDs for the shard number. shards maps shard numbers to Shard
+// IDs; a Shard is only valid for the Combiner if it has a number, so
+// numbers of Shards added later must be mapped from the start.
+func NewNumericCombiner(bits uint, shards map[int64]string) (NumericCombiner, error) {
+	if bits == 0 || bits > 32 {
+		return nil, cErr("shard bits must be between 1 and 32")
+	}
+	c := &numericCombiner{
+		bits: bits,
+		ids:  make(map[int64]string, len(shards)),
+		nums: make(map[string]int64, len(shards)),
+	}
+	for n, id := range shards {
+		if n < 0 || n >= 1<<bits {
+			return nil, cErr("shard number " + strconv.FormatInt(n, 10) + " out of range")
+		}
+		if id == "" {
+			return nil, cErr("shard id is empty")
+		}
+		if _, exists := c.nums[id]; exists {
+			return nil, cErr("duplicate shard id '" + id + "'")
+		}
+		c.ids[n] = id
+		c.nums[id] = n
+	}
+	return c, nil
+}
+
+// NumericCombiner combines non-negative int64 IDs with a shard number into
+// a single int64: id<<bits | number. Its Combiner methods work on the
+// decimal form of the IDs, so generated IDs must be decimal and fit in the
+// remaining 63-bits bits; Next fails for IDs that do not.
+type NumericCombiner interface {
+	Combiner
+
+	// CombineInt combines id with the number of the Shard shardId.
+	CombineInt(id int64, shardId string) (int64, error)
+
+	// ExtractInt extracts id and the Shard ID from a combined ID.
+	ExtractInt(id int64) (int64, string, error)
+
+	// Number returns the shard number of a Shard ID.
+	Number(shardId string) (int64, bool)
+
+	// ShardID returns the Shard ID of a shard number.
+	ShardID(number int64) (string, bool)
+}
+
+type numericCombiner struct {
+	bits uint
+	ids  map[int64]string
+	nums map[string]int64
+}
+
+func (c *numericCombiner) Validate(shardId string) bool {
+	_, exists := c.nums[shardId]
+	return exists
+}
+
+// Combine returns an empty string if id cannot be combined.
+func (c *numericCombiner) Combine(id string, shardId string) string {
+	v, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+	if err != nil {
+		return ""
+	}
+	res, err := c.CombineInt(v, strings.TrimSpace(shardId))
+	if err != nil {
+		return ""
+	}
+	return strconv.FormatInt(res, 10)
+}
+
+func (c *numericCombiner) Extract(id string) (string, string, error) {
+	v, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+	if err != nil {
+		return "", "", ErrIdParseFailed
+	}
+	local, shardId, err := c.ExtractInt(v)
+	if err != nil {
+		return "", "", err
+	}
+	return strconv.FormatInt(local, 10), shardId, nil
+}
+
+func (c *numericCombiner) CombineInt(id int64, shardId string) (int64, error) {
+	n, exists := c.nums[shardId]
+	if !exists {
+		return 0, ErrShardNotFound
+	}
+	if id < 0 || id >= 1<<(63-c.bits) {
+		return 0, ErrIdCombineFailed
+	}
+	return id<<c.bits | n, nil
+}
+
+func (c *numericCombiner) ExtractInt(id int64) (int64, string, error) {
+	if id < 0 {
+		return 0, "", ErrIdParseFailed
+	}
+	shardId, exists := c.ids[id&(1<<c.bits-1)]
+	if !exists {
+		return 0, "", ErrShardNotFound
+	}
+	return id >> c.bits, shardId, nil
+}
+
+func (c *numericCombiner) Number(shardId string) (int64, bool) {
+	n, exists := c.nums[shardId]
+	return n, exists
+}
+
+func (c *numericCombiner) ShardID(number int64) (string, bool) {
+	id, exists := c.ids[number]
+	return id, exists
+}
@@ -0,0 +1,161 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"testing"
+)
+
+func newTestNumericCombiner(t *testing.T) NumericCombiner {
+	t.Helper()
+	c, err := NewNumericCombiner(4, map[int64]string{1: "000001", 2: "000002", 15: "000015"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNewNumericCombiner(t *testing.T) {
+	tests := []struct {
+		name    string
+		bits    uint
+		shards  map[int64]string
+		wantErr bool
+	}{
+		{"ok", 4, map[int64]string{0: "a", 15: "b"}, false},
+		{"no bits", 0, map[int64]string{0: "a"}, true},
+		{"too many bits", 33, map[int64]string{0: "a"}, true},
+		{"number out of range", 4, map[int64]string{16: "a"}, true},
+		{"negative number", 4, map[int64]string{-1: "a"}, true},
+		{"empty id", 4, map[int64]string{1: ""}, true},
+		{"duplicate id", 4, map[int64]string{1: "a", 2: "a"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewNumericCombiner(tt.bits, tt.shards); (err != nil) != tt.wantErr {
+				t.Errorf("NewNumericCombiner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_numericCombiner_CombineInt(t *testing.T) {
+	c := newTestNumericCombiner(t)
+	tests := []struct {
+		name    string
+		id      int64
+		shardId string
+		want    int64
+		wantErr error
+	}{
+		{"first", 1, "000001", 17, nil},
+		{"last shard", 100, "000015", 1615, nil},
+		{"largest id", 1<<59 - 1, "000002", 1<<63 - 1 - 13, nil},
+		{"id too large", 1 << 59, "000001", 0, ErrIdCombineFailed},
+		{"negative id", -1, "000001", 0, ErrIdCombineFailed},
+		{"unknown shard", 1, "000003", 0, ErrShardNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.CombineInt(tt.id, tt.shardId)
+			if err != tt.wantErr {
+				t.Fatalf("CombineInt() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CombineInt() = %v, want %v", got, tt.want)
+			}
+			if err != nil {
+				return
+			}
+			id, shardId, err := c.ExtractInt(got)
+			if err != nil || id != tt.id || shardId != tt.shardId {
+				t.Errorf("ExtractInt() = %v, %v, %v, want %v, %v", id, shardId, err, tt.id, tt.shardId)
+			}
+		})
+	}
+}
+
+func Test_numericCombiner_Extract(t *testing.T) {
+	c := newTestNumericCombiner(t)
+	tests := []struct {
+		name        string
+		id          string
+		wantId      string
+		wantShardId string
+		wantErr     error
+	}{
+		{"ok", "17", "1", "000001", nil},
+		{"spaces", " 1615 ", "100", "000015", nil},
+		{"unmapped number", "19", "", "", ErrShardNotFound},
+		{"negative", "-17", "", "", ErrIdParseFailed},
+		{"not a number", "17@000001", "", "", ErrIdParseFailed},
+		{"empty", "", "", "", ErrIdParseFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, shardId, err := c.Extract(tt.id)
+			if err != tt.wantErr {
+				t.Fatalf("Extract() error = %v, want %v", err, tt.wantErr)
+			}
+			if id != tt.wantId || shardId != tt.wantShardId {
+				t.Errorf("Extract() = %v, %v, want %v, %v", id, shardId, tt.wantId, tt.wantShardId)
+			}
+		})
+	}
+	if got := c.Combine("100", "000015"); got != "1615" {
+		t.Errorf("Combine() = %v, want 1615", got)
+	}
+	if got := c.Combine("186a1", "000001"); got != "" {
+		t.Errorf("Combine() = %v for a non decimal id, want an empty string", got)
+	}
+	if n, ok := c.Number("000015"); !ok || n != 15 {
+		t.Errorf("Number() = %v, %v, want 15", n, ok)
+	}
+	if id, ok := c.ShardID(2); !ok || id != "000002" {
+		t.Errorf("ShardID() = %v, %v, want 000002", id, ok)
+	}
+}
+
+func Test_cluster_Next_numeric(t *testing.T) {
+	com := newTestNumericCombiner(t)
+	shards := []Shard{NewShard("000001", &sql.DB{}, false)}
+	if _, err := NewCluster(testIdGen, com, nil, NewShard("000003", &sql.DB{}, false)); err == nil {
+		t.Error("NewCluster() accepted a shard without a number")
+	}
+	c, err := NewCluster(&decimalGen{41}, com, nil, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, s, err := c.NextContext(context.Background())
+	if err != nil || id != "657" || s != shards[0] {
+		t.Errorf("NextContext() = %v, %v, %v, want 657", id, s, err)
+	}
+	if got, err := c.One(id); err != nil || got != shards[0] {
+		t.Errorf("One() = %v, %v", got, err)
+	}
+	c, err = NewCluster(stringGen("186a1"), com, nil, shards...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Next(); err != ErrIdCombineFailed {
+		t.Errorf("Next() error = %v, want %v", err, ErrIdCombineFailed)
+	}
+}
+
+// decimalGen generates decimal IDs starting at next.
+type decimalGen struct {
+	next int64
+}
+
+func (g *decimalGen) Generate() string {
+	g.next++
+	return strconv.FormatInt(g.next-1, 10)
+}
+
+// stringGen always generates the same ID.
+type stringGen string
+
+func (g stringGen) Generate() string {
+	return string(g)
+}
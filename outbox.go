@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// OutboxMessage is a message stored in the outbox table of a Shard.
+type OutboxMessage struct {
+	// ID is the position of the message in the outbox of its Shard.
+	ID int64
+
+	// Aggregate is the ID of the entity the message is about. Messages of
+	// an aggregate are delivered in the order they were written, provided
+	// they are all written on the Shard the aggregate belongs to by
+	// transactions that do not overlap, e.g. because they lock the
+	// aggregate row.
+	Aggregate string
+
+	// Topic of the message.
+	Topic   string
+	Payload []byte
+}
+
+// NewOutbox returns an Outbox writing to table. The table is expected to
+// look like:
+//
+//	CREATE TABLE outbox (
+//		id        BIGSERIAL PRIMARY KEY, -- BIGINT AUTO_INCREMENT in MySQL
+//		aggregate VARCHAR(255) NOT NULL,
+//		topic     VARCHAR(255) NOT NULL,
+//		payload   BYTEA NOT NULL         -- BLOB in MySQL
+//	)
+func NewOutbox(table string, bind BindStyle) (Outbox, error) {
+	if err := validTable(table); err != nil {
+		return nil, err
+	}
+	return &outbox{
+		insert: bind.rebind("INSERT INTO " + table + " (aggregate, topic, payload) VALUES (?, ?, ?)"),
+	}, nil
+}
+
+// Outbox interface.
+type Outbox interface {
+	// Write stores a message. It is meant to be called with the
+	// transaction of the write the message is about, e.g. inside WithTx,
+	// so the message is stored if and only if the transaction commits.
+	Write(ctx context.Context, tx Execer, aggregate, topic string, payload []byte) error
+}
+
+type outbox struct {
+	insert string
+}
+
+func (o *outbox) Write(ctx context.Context, tx Execer, aggregate, topic string, payload []byte) error {
+	if payload == nil {
+		payload = []byte{}
+	}
+	_, err := tx.ExecContext(ctx, o.insert, aggregate, topic, payload)
+	return err
+}
+
+// OutboxSink delivers outbox messages, e.g. to a message broker.
+type OutboxSink interface {
+	// Deliver delivers the messages of a Shard in order. If it fails, the
+	// same messages are delivered again on the next poll, so a message may
+	// be delivered more than once.
+	Deliver(ctx context.Context, s Shard, msgs []OutboxMessage) error
+}
+
+// OutboxSinkFunc is an OutboxSink implemented by a function.
+type OutboxSinkFunc func(ctx context.Context, s Shard, msgs []OutboxMessage) error
+
+func (fn OutboxSinkFunc) Deliver(ctx context.Context, s Shard, msgs []OutboxMessage) error {
+	return fn(ctx, s, msgs)
+}
+
+// OutboxOptions control an OutboxRelay.
+type OutboxOptions struct {
+	// BatchSize is the maximum number of messages delivered at once.
+	// Defaults to 100.
+	BatchSize int
+
+	// Interval between two polls of Run. Defaults to one second.
+	Interval time.Duration
+
+	// Limit is the maximum number of Shards polled concurrently.
+	Limit int
+
+	// OnError is called by Run with the error of every failed poll.
+	OnError func(error)
+}
+
+// NewOutboxRelay returns an OutboxRelay delivering the messages written to
+// table on every Shard of c to sink. Delivered messages are deleted from
+// the table, so a Shard's outbox only holds the messages still to deliver
+// and a message committed late, after messages with higher IDs, is
+// delivered on the next poll instead of being skipped. A single relay
+// should run per table, as concurrent relays deliver the same messages.
+func NewOutboxRelay(c Cluster, table string, bind BindStyle, sink OutboxSink, opts OutboxOptions) (OutboxRelay, error) {
+	if c == nil || sink == nil {
+		return nil, cErr("cluster and sink cannot be nil")
+	}
+	if err := validTable(table); err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	return &outboxRelay{
+		c:      c,
+		sink:   sink,
+		opts:   opts,
+		query:  bind.rebind("SELECT id, aggregate, topic, payload FROM " + table + " ORDER BY id LIMIT ?"),
+		delete: "DELETE FROM " + table + " WHERE id IN (",
+		bind:   bind,
+	}, nil
+}
+
+// OutboxRelay interface.
+type OutboxRelay interface {
+	// Poll delivers the pending messages of every Shard and reports which
+	// Shards failed. Messages are read in ID order and deleted once they
+	// were delivered; if the deletion fails, they are delivered again.
+	Poll(ctx context.Context) *ScatterResult
+
+	// Run calls Poll every interval until ctx is done.
+	Run(ctx context.Context)
+}
+
+type outboxRelay struct {
+	c      Cluster
+	sink   OutboxSink
+	opts   OutboxOptions
+	query  string
+	delete string
+	bind   BindStyle
+	mu     sync.Mutex
+}
+
+func (r *outboxRelay) Run(ctx context.Context) {
+	t := time.NewTicker(r.opts.Interval)
+	defer t.Stop()
+	for {
+		if err := r.Poll(ctx).Err(); err != nil && r.opts.OnError != nil && ctx.Err() == nil {
+			r.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Poll runs one poll at a time, so a Shard's messages are never delivered
+// by two polls at once.
+func (r *outboxRelay) Poll(ctx context.Context) *ScatterResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	opts := ScatterOptions{Limit: r.opts.Limit, CollectAll: true}
+	return r.c.Scatter(ctx, opts, r.poll)
+}
+
+// poll delivers batches until the outbox of s is drained. A failed batch
+// stops the Shard, so later messages never overtake it.
+func (r *outboxRelay) poll(ctx context.Context, s Shard) error {
+	for {
+		msgs, err := r.read(ctx, s)
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+		if err := r.sink.Deliver(ctx, s, msgs); err != nil {
+			return err
+		}
+		if err := r.remove(ctx, s, msgs); err != nil {
+			return wrapErr(err, "failed to delete delivered messages")
+		}
+		if len(msgs) < r.opts.BatchSize {
+			return nil
+		}
+	}
+}
+
+func (r *outboxRelay) read(ctx context.Context, s Shard) ([]OutboxMessage, error) {
+	rows, err := s.Writer().QueryContext(ctx, r.query, r.opts.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var msgs []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Aggregate, &m.Topic, &m.Payload); err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+func (r *outboxRelay) remove(ctx context.Context, s Shard, msgs []OutboxMessage) error {
+	query := []byte(r.delete)
+	args := make([]interface{}, len(msgs))
+	for i, m := range msgs {
+		if i > 0 {
+			query = append(query, ", "...)
+		}
+		query = append(query, '?')
+		args[i] = m.ID
+	}
+	query = append(query, ')')
+	_, err := s.Writer().ExecContext(ctx, r.bind.rebind(string(query)), args...)
+	return err
+}
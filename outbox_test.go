@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeOutbox serves the outbox of a fake database from ids, which must be
+// sorted, and deletes the delivered ones. It returns a function committing
+// more messages.
+func fakeOutbox(f *fakeDB, ids ...int64) func(...int64) {
+	var mu sync.Mutex
+	f.query = func(_ string, args []driver.NamedValue) (driver.Rows, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		rows := newFakeRows("id,aggregate,topic,payload")
+		for _, id := range ids {
+			if int64(len(rows.rows)) < args[0].Value.(int64) {
+				rows.rows = append(rows.rows, []driver.Value{id, "user-1", "created", []byte("{}")})
+			}
+		}
+		return rows, nil
+	}
+	f.exec = func(_ string, args []driver.NamedValue) (driver.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		deleted := make(map[int64]bool, len(args))
+		for _, a := range args {
+			deleted[a.Value.(int64)] = true
+		}
+		rest := ids[:0]
+		for _, id := range ids {
+			if !deleted[id] {
+				rest = append(rest, id)
+			}
+		}
+		ids = rest
+		return driver.RowsAffected(len(args)), nil
+	}
+	return func(more ...int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		ids = append(ids, more...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	}
+}
+
+// sinkRecorder records the delivered message IDs per Shard.
+type sinkRecorder struct {
+	mu   sync.Mutex
+	got  map[string][]int64
+	fail error
+}
+
+func (r *sinkRecorder) Deliver(_ context.Context, s Shard, msgs []OutboxMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fail != nil {
+		return r.fail
+	}
+	for _, m := range msgs {
+		r.got[s.ID()] = append(r.got[s.ID()], m.ID)
+	}
+	return nil
+}
+
+func Test_outbox_Write(t *testing.T) {
+	f, db := newFakeDB("outbox-write")
+	var args []driver.NamedValue
+	f.exec = func(_ string, a []driver.NamedValue) (driver.Result, error) {
+		args = a
+		return driver.RowsAffected(1), nil
+	}
+	o, err := NewOutbox("outbox", BindDollar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Write(context.Background(), tx, "user-1", "created", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"BEGIN", "INSERT INTO outbox (aggregate, topic, payload) VALUES ($1, $2, $3)", "COMMIT"}
+	if got := f.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Write() statements = %v, want %v", got, want)
+	}
+	if len(args) != 3 || args[0].Value != "user-1" || args[1].Value != "created" {
+		t.Errorf("Write() args = %v", args)
+	}
+	if _, err := NewOutbox("outbox x", BindDollar); err == nil {
+		t.Error("NewOutbox() accepted an invalid table name")
+	}
+}
+
+func Test_outboxRelay_Poll(t *testing.T) {
+	fs, c := newFakeCluster(t, "outbox-poll", 2)
+	fakeOutbox(fs[0], 1, 2, 3, 4, 5)
+	fakeOutbox(fs[1], 1, 2, 4)
+	sink := &sinkRecorder{got: make(map[string][]int64)}
+	r, err := NewOutboxRelay(c, "outbox", BindDollar, sink, OutboxOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := r.Poll(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int64{"000001": {1, 2, 3, 4, 5}, "000002": {1, 2, 4}}
+	if !reflect.DeepEqual(sink.got, want) {
+		t.Errorf("Poll() delivered %v, want %v", sink.got, want)
+	}
+	stmts := []string{
+		"SELECT id, aggregate, topic, payload FROM outbox ORDER BY id LIMIT $1",
+		"DELETE FROM outbox WHERE id IN ($1, $2)",
+		"SELECT id, aggregate, topic, payload FROM outbox ORDER BY id LIMIT $1",
+		"DELETE FROM outbox WHERE id IN ($1)",
+	}
+	if got := fs[1].statements(); !reflect.DeepEqual(got, stmts) {
+		t.Errorf("Poll() statements = %v, want %v", strings.Join(got, "\n"), stmts)
+	}
+	if err := r.Poll(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sink.got, want) {
+		t.Errorf("Poll() delivered %v again, want %v", sink.got, want)
+	}
+}
+
+func Test_outboxRelay_Poll_late(t *testing.T) {
+	fs, c := newFakeCluster(t, "outbox-late", 1)
+	commit := fakeOutbox(fs[0], 1, 2, 4)
+	sink := &sinkRecorder{got: make(map[string][]int64)}
+	r, err := NewOutboxRelay(c, "outbox", BindQuestion, sink, OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := r.Poll(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	// 3 commits after 4 was delivered, however late, and is not skipped.
+	commit(3, 5)
+	if err := r.Poll(ctx).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sink.got["000001"], []int64{1, 2, 4, 3, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Poll() delivered %v, want %v", got, want)
+	}
+}
+
+func Test_outboxRelay_Poll_failure(t *testing.T) {
+	fs, c := newFakeCluster(t, "outbox-failure", 2)
+	fakeOutbox(fs[0], 1, 2)
+	fakeOutbox(fs[1], 1)
+	boom := errors.New("boom")
+	sink := &sinkRecorder{got: make(map[string][]int64), fail: boom}
+	r, err := NewOutboxRelay(c, "outbox", BindQuestion, sink, OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := r.Poll(context.Background())
+	if len(res.Failed) != 2 || !errors.Is(res.Err(), boom) {
+		t.Errorf("Poll() = %v, want both shards failed", res.Failed)
+	}
+	for i, f := range fs {
+		if got := len(f.statements()); got != 1 {
+			t.Errorf("Poll() ran %d statements on shard %d after a failed delivery, want 1", got, i+1)
+		}
+	}
+	sink.fail = nil
+	if err := r.Poll(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]int64{"000001": {1, 2}, "000002": {1}}
+	if !reflect.DeepEqual(sink.got, want) {
+		t.Errorf("Poll() delivered %v, want %v", sink.got, want)
+	}
+}
+
+func Test_outboxRelay_Poll_deleteFailure(t *testing.T) {
+	fs, c := newFakeCluster(t, "outbox-delete-failure", 1)
+	fakeOutbox(fs[0], 1, 2)
+	exec := fs[0].exec
+	fs[0].exec = func(string, []driver.NamedValue) (driver.Result, error) {
+		return nil, errors.New("boom")
+	}
+	sink := &sinkRecorder{got: make(map[string][]int64)}
+	r, err := NewOutboxRelay(c, "outbox", BindQuestion, sink, OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Poll(context.Background()).Err(); err == nil {
+		t.Error("Poll() error = nil")
+	}
+	fs[0].exec = exec
+	if err := r.Poll(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sink.got["000001"], []int64{1, 2, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Poll() delivered %v, want the messages again", got)
+	}
+}
+
+func TestNewOutboxRelay(t *testing.T) {
+	_, c := newFakeCluster(t, "outbox-new", 1)
+	sink := &sinkRecorder{}
+	if _, err := NewOutboxRelay(nil, "outbox", BindQuestion, sink, OutboxOptions{}); err == nil {
+		t.Error("NewOutboxRelay() accepted a nil cluster")
+	}
+	if _, err := NewOutboxRelay(c, "outbox x", BindQuestion, sink, OutboxOptions{}); err == nil {
+		t.Error("NewOutboxRelay() accepted an invalid table name")
+	}
+	r, err := NewOutboxRelay(c, "outbox", BindQuestion, sink, OutboxOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := r.(*outboxRelay).opts; o.BatchSize != 100 || o.Interval != time.Second {
+		t.Errorf("options = %+v, want the defaults", o)
+	}
+}
@@ -0,0 +1,224 @@
+package cluster
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewRoundRobinPlacer returns a Placer that cycles through the writable
+// Shards, ignoring their weights.
+func NewRoundRobinPlacer() Placer {
+	return &roundRobinPlacer{}
+}
+
+// NewRandomPlacer returns a Placer that picks a writable Shard uniformly at
+// random.
+func NewRandomPlacer() Placer {
+	return &randomPlacer{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// NewWeightedPlacer returns a Placer that distributes new IDs in proportion
+// to Shard weights using smooth weighted round-robin, without locking.
+// Shards with a weight of 0 never receive new IDs.
+func NewWeightedPlacer() Placer {
+	return &weightedPlacer{}
+}
+
+// NewLeastLoadedPlacer returns a Placer that picks the writable Shard with
+// the lowest load. If load is nil, the number of in-use database
+// connections is used.
+func NewLeastLoadedPlacer(load func(Shard) int) Placer {
+	if load == nil {
+		load = connLoad
+	}
+	return &leastLoadedPlacer{load}
+}
+
+// Placer interface.
+type Placer interface {
+	// Place returns one of the given writable Shards to receive the next
+	// new ID, or nil if none of them can take it. The slice is only valid
+	// for the duration of the call and must not be retained.
+	Place([]Shard) Shard
+}
+
+// PlacerContext is implemented by Placers that need the request context,
+// e.g. to read tracing data or deadlines. When a Placer implements it,
+// Cluster.NextContext uses PlaceContext instead of Place.
+type PlacerContext interface {
+	// PlaceContext is like Place.
+	PlaceContext(context.Context, []Shard) Shard
+}
+
+var (
+	shardBufs = sync.Pool{
+		New: func() interface{} {
+			return new([]Shard)
+		},
+	}
+)
+
+// writable reports whether s may currently receive new IDs.
+func writable(s Shard) bool {
+	return !s.ReadOnly() && s.Healthy()
+}
+
+type roundRobinPlacer struct {
+	n uint64
+}
+
+func (p *roundRobinPlacer) Place(shards []Shard) Shard {
+	if len(shards) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&p.n, 1)
+	return shards[(n-1)%uint64(len(shards))]
+}
+
+type randomPlacer struct {
+	rnd *rand.Rand
+	mu  sync.Mutex
+}
+
+func (p *randomPlacer) Place(shards []Shard) Shard {
+	if len(shards) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	i := p.rnd.Intn(len(shards))
+	p.mu.Unlock()
+	return shards[i]
+}
+
+type weightedPlacer struct {
+	n     uint64
+	sched atomic.Value
+}
+
+// weightedSchedule is one period of smooth weighted round-robin over the
+// shards it was built from.
+type weightedSchedule struct {
+	shards  []Shard
+	weights []int
+	seq     []Shard
+}
+
+// maxWeightedPeriod bounds the length of a schedule. Weights adding up to
+// more, once divided by their greatest common divisor, are scaled down.
+const maxWeightedPeriod = 1024
+
+// Place is lock free: it steps through a precomputed period of smooth
+// weighted round-robin, which is rebuilt when the shards or their weights
+// change. Equal weights degrade to plain round-robin.
+func (p *weightedPlacer) Place(shards []Shard) Shard {
+	sc, _ := p.sched.Load().(*weightedSchedule)
+	if sc == nil || !sc.matches(shards) {
+		sc = newWeightedSchedule(shards)
+		p.sched.Store(sc)
+	}
+	if len(sc.seq) == 0 {
+		return nil
+	}
+	n := atomic.AddUint64(&p.n, 1)
+	return sc.seq[(n-1)%uint64(len(sc.seq))]
+}
+
+// newWeightedSchedule runs smooth weighted round-robin for one period: on
+// every step each shard's current weight grows by its weight, the shard with
+// the highest current weight wins and is pushed back by the total.
+func newWeightedSchedule(shards []Shard) *weightedSchedule {
+	sc := &weightedSchedule{
+		shards:  make([]Shard, len(shards)),
+		weights: make([]int, len(shards)),
+	}
+	copy(sc.shards, shards)
+	g, total := 0, 0
+	for i, s := range shards {
+		w := s.Weight()
+		sc.weights[i] = w
+		if w > 0 {
+			g = gcd(g, w)
+			total += w
+		}
+	}
+	if total == 0 {
+		return sc
+	}
+	ws := make([]int, len(shards))
+	period := 0
+	for i, w := range sc.weights {
+		if w <= 0 {
+			continue
+		}
+		ws[i] = w / g
+		if total/g > maxWeightedPeriod {
+			ws[i] = w * maxWeightedPeriod / total
+			if ws[i] == 0 {
+				ws[i] = 1
+			}
+		}
+		period += ws[i]
+	}
+	sc.seq = make([]Shard, period)
+	cw := make([]int, len(shards))
+	for k := range sc.seq {
+		best := -1
+		for i, w := range ws {
+			if w == 0 {
+				continue
+			}
+			cw[i] += w
+			if best == -1 || cw[i] > cw[best] {
+				best = i
+			}
+		}
+		cw[best] -= period
+		sc.seq[k] = shards[best]
+	}
+	return sc
+}
+
+// matches reports whether the schedule was built from shards with their
+// current weights.
+func (sc *weightedSchedule) matches(shards []Shard) bool {
+	if len(shards) != len(sc.shards) {
+		return false
+	}
+	for i, s := range shards {
+		if s != sc.shards[i] || s.Weight() != sc.weights[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+type leastLoadedPlacer struct {
+	load func(Shard) int
+}
+
+func (p *leastLoadedPlacer) Place(shards []Shard) Shard {
+	var (
+		best Shard
+		min  int
+	)
+	for _, s := range shards {
+		if l := p.load(s); best == nil || l < min {
+			best, min = s, l
+		}
+	}
+	return best
+}
+
+func connLoad(s Shard) int {
+	return s.Conn().Stats().InUse
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func Test_roundRobinPlacer_Place(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+		NewShard("000003", &sql.DB{}, false),
+	}
+	p := NewRoundRobinPlacer()
+	tests := []struct {
+		name string
+		want Shard
+	}{
+		{"000001", shards[0]},
+		{"000002", shards[1]},
+		{"000003", shards[2]},
+		{"000001", shards[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Place(shards); got != tt.want {
+				t.Errorf("Place() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := p.Place(nil); got != nil {
+		t.Errorf("Place() = %v, want nil", got)
+	}
+}
+
+func Test_randomPlacer_Place(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+	}
+	p := NewRandomPlacer()
+	seen := make(map[Shard]int)
+	for i := 0; i < 1000; i++ {
+		seen[p.Place(shards)]++
+	}
+	if len(seen) != 2 || seen[shards[0]] == 0 || seen[shards[1]] == 0 {
+		t.Errorf("Place() distribution = %v", seen)
+	}
+	if got := p.Place(nil); got != nil {
+		t.Errorf("Place() = %v, want nil", got)
+	}
+}
+
+func Test_weightedPlacer_Place(t *testing.T) {
+	shards := []Shard{
+		NewWeightedShard("000001", &sql.DB{}, false, 5),
+		NewWeightedShard("000002", &sql.DB{}, false, 1),
+		NewWeightedShard("000003", &sql.DB{}, false, 1),
+	}
+	p := NewWeightedPlacer()
+	want := []Shard{
+		shards[0], shards[0], shards[1], shards[0],
+		shards[2], shards[0], shards[0],
+	}
+	for i, w := range want {
+		if got := p.Place(shards); got != w {
+			t.Errorf("Place() #%d = %v, want %v", i, got.ID(), w.ID())
+		}
+	}
+	shards[0].SetWeight(0)
+	for i := 0; i < 4; i++ {
+		if got := p.Place(shards); got == shards[0] {
+			t.Errorf("Place() #%d picked shard with zero weight", i)
+		}
+	}
+	if got := p.Place(shards[1:2]); got != shards[1] {
+		t.Errorf("Place() = %v, want %v", got, shards[1])
+	}
+	shards[1].SetWeight(0)
+	if got := p.Place(shards[1:2]); got != nil {
+		t.Errorf("Place() = %v, want nil", got)
+	}
+}
+
+func Test_weightedPlacer_Place_scaled(t *testing.T) {
+	shards := []Shard{
+		NewWeightedShard("000001", &sql.DB{}, false, 3000),
+		NewWeightedShard("000002", &sql.DB{}, false, 1001),
+	}
+	p := NewWeightedPlacer()
+	seen := make(map[Shard]int)
+	for i := 0; i < 4*maxWeightedPeriod; i++ {
+		seen[p.Place(shards)]++
+	}
+	if got := seen[shards[0]]; got < 3000 || got > 3100 {
+		t.Errorf("Place() picked %v %d times out of %d", shards[0].ID(), got, 4*maxWeightedPeriod)
+	}
+	if l := len(p.(*weightedPlacer).sched.Load().(*weightedSchedule).seq); l > maxWeightedPeriod {
+		t.Errorf("schedule period = %d, want at most %d", l, maxWeightedPeriod)
+	}
+}
+
+func Test_leastLoadedPlacer_Place(t *testing.T) {
+	shards := []Shard{
+		NewShard("000001", &sql.DB{}, false),
+		NewShard("000002", &sql.DB{}, false),
+		NewShard("000003", &sql.DB{}, false),
+	}
+	load := map[Shard]int{shards[0]: 3, shards[1]: 1, shards[2]: 2}
+	p := NewLeastLoadedPlacer(func(s Shard) int { return load[s] })
+	if got := p.Place(shards); got != shards[1] {
+		t.Errorf("Place() = %v, want %v", got, shards[1])
+	}
+	load[shards[2]] = 0
+	if got := p.Place(shards); got != shards[2] {
+		t.Errorf("Place() = %v, want %v", got, shards[2])
+	}
+	if got := NewLeastLoadedPlacer(nil).Place(shards); got != shards[0] {
+		t.Errorf("Place() = %v, want %v", got, shards[0])
+	}
+	if got := p.Place(nil); got != nil {
+		t.Errorf("Place() = %v, want nil", got)
+	}
+}
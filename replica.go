@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	"database/sql"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NewRoundRobinReplicaPolicy returns a ReplicaPolicy that cycles through
+// the healthy replicas.
+func NewRoundRobinReplicaPolicy() ReplicaPolicy {
+	return &roundRobinReplicas{}
+}
+
+// NewRandomReplicaPolicy returns a ReplicaPolicy that picks a healthy
+// replica uniformly at random.
+func NewRandomReplicaPolicy() ReplicaPolicy {
+	return &randomReplicas{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// NewLeastInFlightReplicaPolicy returns a ReplicaPolicy that picks the
+// healthy replica with the fewest in-use connections.
+func NewLeastInFlightReplicaPolicy() ReplicaPolicy {
+	return leastInFlightReplicas{}
+}
+
+// ReplicaPolicy interface.
+type ReplicaPolicy interface {
+	// Pick returns one of the given healthy replicas. The slice is never
+	// empty and must not be modified or retained.
+	Pick([]*sql.DB) *sql.DB
+}
+
+type roundRobinReplicas struct {
+	n uint64
+}
+
+func (p *roundRobinReplicas) Pick(replicas []*sql.DB) *sql.DB {
+	n := atomic.AddUint64(&p.n, 1)
+	return replicas[(n-1)%uint64(len(replicas))]
+}
+
+type randomReplicas struct {
+	rnd *rand.Rand
+	mu  sync.Mutex
+}
+
+func (p *randomReplicas) Pick(replicas []*sql.DB) *sql.DB {
+	p.mu.Lock()
+	i := p.rnd.Intn(len(replicas))
+	p.mu.Unlock()
+	return replicas[i]
+}
+
+type leastInFlightReplicas struct{}
+
+func (leastInFlightReplicas) Pick(replicas []*sql.DB) *sql.DB {
+	best, min := replicas[0], replicas[0].Stats().InUse
+	for _, db := range replicas[1:] {
+		if n := db.Stats().InUse; n < min {
+			best, min = db, n
+		}
+	}
+	return best
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+)
+
+func Test_roundRobinReplicas_Pick(t *testing.T) {
+	replicas := []*sql.DB{{}, {}, {}}
+	p := NewRoundRobinReplicaPolicy()
+	tests := []struct {
+		name string
+		want *sql.DB
+	}{
+		{"first", replicas[0]},
+		{"second", replicas[1]},
+		{"third", replicas[2]},
+		{"first again", replicas[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.Pick(replicas); got != tt.want {
+				t.Errorf("Pick() = %p, want %p", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_randomReplicas_Pick(t *testing.T) {
+	replicas := []*sql.DB{{}, {}}
+	p := NewRandomReplicaPolicy()
+	seen := make(map[*sql.DB]int)
+	for i := 0; i < 1000; i++ {
+		seen[p.Pick(replicas)]++
+	}
+	if seen[replicas[0]] == 0 || seen[replicas[1]] == 0 {
+		t.Errorf("Pick() distribution = %v", seen)
+	}
+}
+
+func Test_leastInFlightReplicas_Pick(t *testing.T) {
+	_, busy := newFakeDB("replica-busy")
+	_, idle := newFakeDB("replica-idle")
+	conn, err := busy.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p := NewLeastInFlightReplicaPolicy()
+	if got := p.Pick([]*sql.DB{busy, idle}); got != idle {
+		t.Errorf("Pick() = %p, want %p", got, idle)
+	}
+	if got := p.Pick([]*sql.DB{idle, busy}); got != idle {
+		t.Errorf("Pick() = %p, want %p", got, idle)
+	}
+}
@@ -0,0 +1,342 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+)
+
+// Saga statuses.
+const (
+	SagaRunning      = "running"
+	SagaCompensating = "compensating"
+	SagaCompleted    = "completed"
+	SagaAborted      = "aborted"
+)
+
+// SagaFunc runs an action or a compensation of a saga step against the
+// Shard the step ID belongs to. Since a step interrupted by a crash is run
+// again on resume, it must be idempotent.
+type SagaFunc func(ctx context.Context, s Shard, id string) error
+
+// SagaStep is a single step of a Saga. Compensate undoes a successful
+// Action and may be nil if there is nothing to undo. A failed Action is
+// not compensated, so it should leave no changes behind, e.g. by running
+// in a transaction.
+type SagaStep struct {
+	Name       string
+	Action     SagaFunc
+	Compensate SagaFunc
+}
+
+// Saga is a named sequence of steps. The name identifies the saga in the
+// log, so it must stay the same across restarts.
+type Saga struct {
+	Name  string
+	Steps []SagaStep
+}
+
+// SagaState is the persisted progress of a saga run.
+type SagaState struct {
+	// ID of the run.
+	ID string
+
+	// Saga name.
+	Saga string
+
+	// Node that started the run.
+	Node string
+
+	// IDs the steps are bound to, one per step.
+	IDs []string
+
+	// Step is the number of steps whose action has completed and was not
+	// compensated yet.
+	Step int
+
+	// Status of the run.
+	Status string
+
+	// Failed is the name of the step whose action failed, if any.
+	Failed string
+
+	// Error of the failed action, if any.
+	Error string
+}
+
+// SagaLog persists the progress of saga runs.
+type SagaLog interface {
+	// Create records a new run.
+	Create(ctx context.Context, st *SagaState) error
+
+	// Update records the progress of a run.
+	Update(ctx context.Context, st *SagaState) error
+
+	// Pending returns the runs of node that are still running or
+	// compensating.
+	Pending(ctx context.Context, node string) ([]*SagaState, error)
+}
+
+// SagaError is returned when a saga run fails.
+type SagaError struct {
+	// ID of the run.
+	ID string
+
+	// Step whose action failed.
+	Step string
+
+	// Err is the action error. If the run could not be compensated, it is
+	// the compensation error instead.
+	Err error
+
+	// Compensated is true if all the completed steps were compensated.
+	Compensated bool
+}
+
+func (e *SagaError) Error() string {
+	msg := "saga '" + e.ID + "' step '" + e.Step + "': " + e.Err.Error()
+	if !e.Compensated {
+		msg += " (compensation pending)"
+	}
+	return msg
+}
+
+func (e *SagaError) Unwrap() error {
+	return e.Err
+}
+
+// NewSagaRunner returns a SagaRunner for the given sagas. Like for a
+// Coordinator, the node name must be unique among the processes sharing
+// the log: Resume only picks up the runs of its own node.
+func NewSagaRunner(c Cluster, log SagaLog, node string, sagas ...Saga) (SagaRunner, error) {
+	if c == nil || log == nil {
+		return nil, cErr("cluster and log cannot be nil")
+	}
+	if node == "" {
+		return nil, cErr("node name is empty")
+	}
+	r := &sagaRunner{c: c, log: log, node: node, sagas: make(map[string]*Saga, len(sagas))}
+	for i := range sagas {
+		sg := &sagas[i]
+		if sg.Name == "" {
+			return nil, cErr("saga name is empty")
+		}
+		if len(sg.Steps) == 0 {
+			return nil, cErr("saga '" + sg.Name + "' has no steps")
+		}
+		for _, st := range sg.Steps {
+			if st.Action == nil {
+				return nil, cErr("saga '" + sg.Name + "' step '" + st.Name + "' has no action")
+			}
+		}
+		if _, exists := r.sagas[sg.Name]; exists {
+			return nil, cErr("duplicate saga '" + sg.Name + "'")
+		}
+		r.sagas[sg.Name] = sg
+	}
+	return r, nil
+}
+
+// SagaRunner interface.
+type SagaRunner interface {
+	// Run starts the named saga with one ID per step and runs it to the
+	// end. If a step fails, the completed steps are compensated in reverse
+	// order and a *SagaError is returned. A run whose compensation fails
+	// is left to Resume.
+	Run(ctx context.Context, saga string, ids ...string) error
+
+	// Resume continues the unfinished runs of this node, e.g. after a
+	// crash. It must not run concurrently with Run on the same node.
+	Resume(ctx context.Context) error
+}
+
+type sagaRunner struct {
+	c     Cluster
+	log   SagaLog
+	node  string
+	sagas map[string]*Saga
+}
+
+func (r *sagaRunner) Run(ctx context.Context, saga string, ids ...string) error {
+	sg, exists := r.sagas[saga]
+	if !exists {
+		return cErr("unknown saga '" + saga + "'")
+	}
+	if len(ids) != len(sg.Steps) {
+		return cErr("saga '" + saga + "' needs one id per step")
+	}
+	for _, id := range ids {
+		if _, err := r.c.OneContext(ctx, id); err != nil {
+			return err
+		}
+	}
+	gid, err := newGid()
+	if err != nil {
+		return err
+	}
+	st := &SagaState{
+		ID:     gid,
+		Saga:   saga,
+		Node:   r.node,
+		IDs:    ids,
+		Status: SagaRunning,
+	}
+	if err := r.log.Create(ctx, st); err != nil {
+		return wrapErr(err, "failed to create saga")
+	}
+	return r.run(ctx, sg, st)
+}
+
+func (r *sagaRunner) Resume(ctx context.Context) error {
+	pending, err := r.log.Pending(ctx, r.node)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, st := range pending {
+		sg, exists := r.sagas[st.Saga]
+		if !exists || len(st.IDs) != len(sg.Steps) {
+			err = cErr("cannot resume saga '" + st.ID + "': unknown saga '" + st.Saga + "'")
+		} else {
+			err = r.run(ctx, sg, st)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// run drives st forward, recording every step before the next one starts.
+func (r *sagaRunner) run(ctx context.Context, sg *Saga, st *SagaState) error {
+	var cause error
+	for st.Status == SagaRunning && st.Step < len(sg.Steps) {
+		err := r.step(ctx, sg.Steps[st.Step].Action, st.IDs[st.Step])
+		if err != nil {
+			cause = err
+			st.Status = SagaCompensating
+			st.Failed = sg.Steps[st.Step].Name
+			st.Error = err.Error()
+		} else {
+			st.Step++
+		}
+		if err := r.update(ctx, st); err != nil {
+			return err
+		}
+	}
+	if st.Status == SagaRunning {
+		st.Status = SagaCompleted
+		return r.update(ctx, st)
+	}
+	for st.Step > 0 {
+		step := sg.Steps[st.Step-1]
+		if step.Compensate != nil {
+			if err := r.step(ctx, step.Compensate, st.IDs[st.Step-1]); err != nil {
+				return &SagaError{st.ID, st.Failed, err, false}
+			}
+		}
+		st.Step--
+		if err := r.update(ctx, st); err != nil {
+			return err
+		}
+	}
+	st.Status = SagaAborted
+	if err := r.update(ctx, st); err != nil {
+		return err
+	}
+	if cause == nil {
+		cause = cErr(st.Error)
+	}
+	return &SagaError{st.ID, st.Failed, cause, true}
+}
+
+func (r *sagaRunner) step(ctx context.Context, fn SagaFunc, id string) error {
+	s, err := r.c.OneContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, s, id); err != nil {
+		return &ShardError{s, err}
+	}
+	return nil
+}
+
+func (r *sagaRunner) update(ctx context.Context, st *SagaState) error {
+	if err := r.log.Update(ctx, st); err != nil {
+		return wrapErr(err, "failed to update saga")
+	}
+	return nil
+}
+
+// NewSQLSagaLog returns a SagaLog storing the runs in a table on the
+// primary database of s. The table is expected to look like:
+//
+//	CREATE TABLE sagas (
+//		id     VARCHAR(64) PRIMARY KEY,
+//		saga   VARCHAR(255) NOT NULL,
+//		node   VARCHAR(255) NOT NULL,
+//		ids    TEXT NOT NULL,
+//		step   INT NOT NULL,
+//		status VARCHAR(16) NOT NULL,
+//		failed VARCHAR(255) NOT NULL,
+//		error  TEXT NOT NULL
+//	)
+func NewSQLSagaLog(s Shard, table string, bind BindStyle) (SagaLog, error) {
+	if s == nil {
+		return nil, cErr("shard cannot be nil")
+	}
+	if err := validTable(table); err != nil {
+		return nil, err
+	}
+	return &sqlSagaLog{
+		s: s,
+		create: bind.rebind("INSERT INTO " + table +
+			" (id, saga, node, ids, step, status, failed, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		update: bind.rebind("UPDATE " + table +
+			" SET step = ?, status = ?, failed = ?, error = ? WHERE id = ?"),
+		pending: bind.rebind("SELECT id, saga, node, ids, step, status, failed, error FROM " + table +
+			" WHERE node = ? AND status IN ('" + SagaRunning + "', '" + SagaCompensating + "') ORDER BY id"),
+	}, nil
+}
+
+type sqlSagaLog struct {
+	s       Shard
+	create  string
+	update  string
+	pending string
+}
+
+func (l *sqlSagaLog) Create(ctx context.Context, st *SagaState) error {
+	ids, err := json.Marshal(st.IDs)
+	if err != nil {
+		return err
+	}
+	_, err = l.s.Writer().ExecContext(ctx, l.create, st.ID, st.Saga, st.Node, string(ids), st.Step, st.Status, st.Failed, st.Error)
+	return err
+}
+
+func (l *sqlSagaLog) Update(ctx context.Context, st *SagaState) error {
+	_, err := l.s.Writer().ExecContext(ctx, l.update, st.Step, st.Status, st.Failed, st.Error, st.ID)
+	return err
+}
+
+func (l *sqlSagaLog) Pending(ctx context.Context, node string) ([]*SagaState, error) {
+	rows, err := l.s.Writer().QueryContext(ctx, l.pending, node)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var res []*SagaState
+	for rows.Next() {
+		st := &SagaState{}
+		var ids sql.RawBytes
+		if err := rows.Scan(&st.ID, &st.Saga, &st.Node, &ids, &st.Step, &st.Status, &st.Failed, &st.Error); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(ids, &st.IDs); err != nil {
+			return nil, wrapErr(err, "invalid ids of saga '"+st.ID+"'")
+		}
+		res = append(res, st)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,292 @@
+package cluster
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// memSagaLog keeps saga runs in memory.
+type memSagaLog struct {
+	mu   sync.Mutex
+	runs map[string]SagaState
+	fail error
+}
+
+func newMemSagaLog() *memSagaLog {
+	return &memSagaLog{runs: make(map[string]SagaState)}
+}
+
+func (l *memSagaLog) Create(_ context.Context, st *SagaState) error {
+	return l.Update(context.Background(), st)
+}
+
+func (l *memSagaLog) Update(_ context.Context, st *SagaState) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.fail != nil {
+		return l.fail
+	}
+	l.runs[st.ID] = *st
+	return nil
+}
+
+func (l *memSagaLog) Pending(_ context.Context, node string) ([]*SagaState, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var res []*SagaState
+	for _, st := range l.runs {
+		if st.Node == node && (st.Status == SagaRunning || st.Status == SagaCompensating) {
+			st := st
+			res = append(res, &st)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res, nil
+}
+
+func (l *memSagaLog) statuses() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var res []string
+	for _, st := range l.runs {
+		res = append(res, st.Status)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// sagaRecorder builds saga steps that record their calls and fail on
+// demand.
+type sagaRecorder struct {
+	mu    sync.Mutex
+	calls []string
+	fail  map[string]error
+}
+
+func (r *sagaRecorder) fn(name string) SagaFunc {
+	return func(_ context.Context, s Shard, id string) error {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.calls = append(r.calls, name+" "+s.ID())
+		return r.fail[name]
+	}
+}
+
+func (r *sagaRecorder) saga() Saga {
+	return Saga{
+		Name: "transfer",
+		Steps: []SagaStep{
+			{"debit", r.fn("debit"), r.fn("undo debit")},
+			{"notify", r.fn("notify"), nil},
+			{"credit", r.fn("credit"), r.fn("undo credit")},
+		},
+	}
+}
+
+func Test_sagaRunner_Run(t *testing.T) {
+	_, c := newFakeCluster(t, "saga-run", 2)
+	boom := errors.New("boom")
+	ids := []string{"1@000001", "2@000001", "3@000002"}
+	tests := []struct {
+		name       string
+		fail       map[string]error
+		wantErr    error
+		wantStatus string
+		want       []string
+	}{
+		{
+			"completed",
+			nil,
+			nil,
+			SagaCompleted,
+			[]string{"debit 000001", "notify 000001", "credit 000002"},
+		},
+		{
+			"compensated",
+			map[string]error{"credit": boom},
+			boom,
+			SagaAborted,
+			[]string{"debit 000001", "notify 000001", "credit 000002", "undo debit 000001"},
+		},
+		{
+			"first step",
+			map[string]error{"debit": boom},
+			boom,
+			SagaAborted,
+			[]string{"debit 000001"},
+		},
+		{
+			"compensation failure",
+			map[string]error{"credit": boom, "undo debit": boom},
+			boom,
+			SagaCompensating,
+			[]string{"debit 000001", "notify 000001", "credit 000002", "undo debit 000001"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &sagaRecorder{fail: tt.fail}
+			log := newMemSagaLog()
+			r, err := NewSagaRunner(c, log, "n1", rec.saga())
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Run(context.Background(), "transfer", ids...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var se *SagaError
+				if !errors.As(err, &se) || se.Step != "credit" && se.Step != "debit" {
+					t.Errorf("Run() error = %#v, want a *SagaError", err)
+				}
+			}
+			if !reflect.DeepEqual(rec.calls, tt.want) {
+				t.Errorf("Run() calls = %v, want %v", rec.calls, tt.want)
+			}
+			if got := log.statuses(); !reflect.DeepEqual(got, []string{tt.wantStatus}) {
+				t.Errorf("Run() statuses = %v, want %v", got, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func Test_sagaRunner_Run_invalid(t *testing.T) {
+	_, c := newFakeCluster(t, "saga-invalid", 2)
+	log := newMemSagaLog()
+	r, err := NewSagaRunner(c, log, "n1", (&sagaRecorder{}).saga())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		saga    string
+		ids     []string
+		wantErr error
+	}{
+		{"unknown saga", "refund", []string{"1@000001", "2@000001", "3@000002"}, nil},
+		{"missing id", "transfer", []string{"1@000001", "2@000001"}, nil},
+		{"unknown shard", "transfer", []string{"1@000001", "2@000001", "3@000009"}, ErrShardNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Run(context.Background(), tt.saga, tt.ids...)
+			if err == nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if got := log.statuses(); len(got) != 0 {
+		t.Errorf("Run() created runs %v", got)
+	}
+}
+
+func Test_sagaRunner_Resume(t *testing.T) {
+	_, c := newFakeCluster(t, "saga-resume", 2)
+	ctx := context.Background()
+	log := newMemSagaLog()
+	ids := []string{"1@000001", "2@000001", "3@000002"}
+	for _, st := range []SagaState{
+		{ID: "a", Saga: "transfer", Node: "n1", IDs: ids, Step: 2, Status: SagaRunning},
+		{ID: "b", Saga: "transfer", Node: "n1", IDs: ids, Step: 1, Status: SagaCompensating, Failed: "notify", Error: "boom"},
+		{ID: "c", Saga: "transfer", Node: "n2", IDs: ids, Step: 0, Status: SagaRunning},
+	} {
+		st := st
+		_ = log.Create(ctx, &st)
+	}
+	rec := &sagaRecorder{}
+	r, err := NewSagaRunner(c, log, "n1", rec.saga())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Resume(ctx)
+	var se *SagaError
+	if !errors.As(err, &se) || se.ID != "b" || se.Step != "notify" || !se.Compensated || se.Err.Error() != "boom" {
+		t.Errorf("Resume() error = %v, want the aborted saga", err)
+	}
+	want := []string{"credit 000002", "undo debit 000001"}
+	if !reflect.DeepEqual(rec.calls, want) {
+		t.Errorf("Resume() calls = %v, want %v", rec.calls, want)
+	}
+	want = []string{SagaAborted, SagaCompleted, SagaRunning}
+	if got := log.statuses(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Resume() statuses = %v, want %v", got, want)
+	}
+}
+
+func TestNewSagaRunner(t *testing.T) {
+	_, c := newFakeCluster(t, "saga-new", 1)
+	valid := (&sagaRecorder{}).saga()
+	tests := []struct {
+		name    string
+		node    string
+		sagas   []Saga
+		wantErr bool
+	}{
+		{"ok", "n1", []Saga{valid}, false},
+		{"empty node", "", []Saga{valid}, true},
+		{"empty name", "n1", []Saga{{Steps: valid.Steps}}, true},
+		{"no steps", "n1", []Saga{{Name: "x"}}, true},
+		{"no action", "n1", []Saga{{Name: "x", Steps: []SagaStep{{Name: "a"}}}}, true},
+		{"duplicate", "n1", []Saga{valid, valid}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSagaRunner(c, newMemSagaLog(), tt.node, tt.sagas...); (err != nil) != tt.wantErr {
+				t.Errorf("NewSagaRunner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_sqlSagaLog(t *testing.T) {
+	f, db := newFakeDB("saga-log")
+	var args [][]driver.NamedValue
+	f.exec = func(_ string, a []driver.NamedValue) (driver.Result, error) {
+		args = append(args, a)
+		return driver.RowsAffected(1), nil
+	}
+	f.query = func(string, []driver.NamedValue) (driver.Rows, error) {
+		return newFakeRows("id,saga,node,ids,step,status,failed,error",
+			[]driver.Value{"a", "transfer", "n1", `["1@000001","2@000002"]`, int64(1), SagaRunning, "", ""}), nil
+	}
+	l, err := NewSQLSagaLog(NewShard("000001", db, false), "app.sagas", BindDollar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	st := &SagaState{ID: "a", Saga: "transfer", Node: "n1", IDs: []string{"1@000001", "2@000002"}, Status: SagaRunning}
+	if err := l.Create(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	st.Step = 1
+	if err := l.Update(ctx, st); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := l.Pending(ctx, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || !reflect.DeepEqual(*pending[0], *st) {
+		t.Errorf("Pending() = %v, want %v", pending, st)
+	}
+	want := []string{
+		"INSERT INTO app.sagas (id, saga, node, ids, step, status, failed, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		"UPDATE app.sagas SET step = $1, status = $2, failed = $3, error = $4 WHERE id = $5",
+		"SELECT id, saga, node, ids, step, status, failed, error FROM app.sagas WHERE node = $1 AND status IN ('running', 'compensating') ORDER BY id",
+	}
+	if got := f.statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %v, want %v", got, want)
+	}
+	if got := args[0][3].Value; got != `["1@000001","2@000002"]` {
+		t.Errorf("Create() ids = %v", got)
+	}
+	if _, err := NewSQLSagaLog(NewShard("000001", db, false), "sagas; DROP TABLE x", BindQuestion); err == nil {
+		t.Error("NewSQLSagaLog() accepted an invalid table name")
+	}
+}
@@ -0,0 +1,139 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ScatterFunc is run against a single Shard by Scatter.
+type ScatterFunc func(context.Context, Shard) error
+
+// ScatterOptions control how Scatter runs.
+type ScatterOptions struct {
+	// Limit is the maximum number of Shards processed concurrently. Zero
+	// means no limit.
+	Limit int
+
+	// Timeout bounds the time spent on a single Shard. Zero means no
+	// timeout.
+	Timeout time.Duration
+
+	// CollectAll keeps processing the remaining Shards after a failure
+	// instead of cancelling them.
+	CollectAll bool
+}
+
+// ScatterResult reports which Shards succeeded and which failed.
+type ScatterResult struct {
+	// Succeeded Shards, in the order they were given.
+	Succeeded []Shard
+
+	// Failed Shards with their errors. Shards cancelled because of an
+	// earlier failure are reported with the context error.
+	Failed map[Shard]error
+
+	first *ShardError
+}
+
+// Err returns the failure that stopped Scatter, or the first failure in
+// CollectAll mode, as a *ShardError. It returns nil if every Shard
+// succeeded.
+func (r *ScatterResult) Err() error {
+	if r.first == nil {
+		return nil
+	}
+	return r.first
+}
+
+// ShardError is an error that occurred on a specific Shard.
+type ShardError struct {
+	Shard Shard
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return "shard '" + e.Shard.ID() + "': " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// scatter runs fn against every shard concurrently. Unless CollectAll is
+// set, the first failure cancels the context passed to the other calls and
+// shards that have not started yet are skipped.
+func scatter(ctx context.Context, shards []Shard, opts ScatterOptions, fn ScatterFunc) *ScatterResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var sem chan struct{}
+	if opts.Limit > 0 {
+		sem = make(chan struct{}, opts.Limit)
+	}
+	var (
+		errs = make([]error, len(shards))
+		res  = &ScatterResult{Failed: make(map[Shard]error)}
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+	fail := func(s Shard, err error) {
+		mu.Lock()
+		if res.first == nil {
+			res.first = &ShardError{s, err}
+			if !opts.CollectAll {
+				cancel()
+			}
+		}
+		mu.Unlock()
+	}
+	wg.Add(len(shards))
+	for i, s := range shards {
+		go func(i int, s Shard) {
+			defer wg.Done()
+			if sem != nil {
+				select {
+				case sem <- struct{}{}:
+					defer func() { <-sem }()
+				case <-ctx.Done():
+					errs[i] = ctx.Err()
+					return
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				errs[i] = err
+				return
+			}
+			if errs[i] = run(ctx, s, opts.Timeout, fn); errs[i] != nil {
+				fail(s, errs[i])
+			}
+		}(i, s)
+	}
+	wg.Wait()
+	for i, s := range shards {
+		if errs[i] != nil {
+			res.Failed[s] = errs[i]
+		} else {
+			res.Succeeded = append(res.Succeeded, s)
+		}
+	}
+	if res.first == nil && len(res.Failed) > 0 {
+		// The parent context was done before any call failed.
+		for _, s := range shards {
+			if err, failed := res.Failed[s]; failed {
+				res.first = &ShardError{s, err}
+				break
+			}
+		}
+	}
+	return res
+}
+
+func run(ctx context.Context, s Shard, timeout time.Duration, fn ScatterFunc) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return fn(ctx, s)
+}
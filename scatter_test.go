@@ -0,0 +1,148 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_cluster_Scatter(t *testing.T) {
+	_, c := newFakeCluster(t, "scatter", 4)
+	shards := c.All()
+	boom := errors.New("boom")
+	tests := []struct {
+		name          string
+		opts          ScatterOptions
+		fn            ScatterFunc
+		wantSucceeded []Shard
+		wantFailed    []Shard
+		wantErr       error
+	}{
+		{
+			"all succeed",
+			ScatterOptions{},
+			func(context.Context, Shard) error { return nil },
+			shards,
+			nil,
+			nil,
+		},
+		{
+			"fail fast",
+			ScatterOptions{},
+			func(ctx context.Context, s Shard) error {
+				if s == shards[2] {
+					return boom
+				}
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			nil,
+			shards,
+			boom,
+		},
+		{
+			"collect all",
+			ScatterOptions{CollectAll: true},
+			func(ctx context.Context, s Shard) error {
+				if s == shards[1] || s == shards[3] {
+					return boom
+				}
+				return nil
+			},
+			[]Shard{shards[0], shards[2]},
+			[]Shard{shards[1], shards[3]},
+			boom,
+		},
+		{
+			"timeout",
+			ScatterOptions{Timeout: time.Millisecond, CollectAll: true},
+			func(ctx context.Context, s Shard) error {
+				if s == shards[0] {
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return nil
+			},
+			shards[1:],
+			shards[:1],
+			context.DeadlineExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := c.Scatter(context.Background(), tt.opts, tt.fn)
+			if !reflect.DeepEqual(res.Succeeded, tt.wantSucceeded) {
+				t.Errorf("Scatter() succeeded = %v, want %v", res.Succeeded, tt.wantSucceeded)
+			}
+			if len(res.Failed) != len(tt.wantFailed) {
+				t.Errorf("Scatter() failed = %v, want %v", res.Failed, tt.wantFailed)
+			}
+			for _, s := range tt.wantFailed {
+				if _, failed := res.Failed[s]; !failed {
+					t.Errorf("Scatter() shard %v did not fail", s.ID())
+				}
+			}
+			if err := res.Err(); !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_cluster_Scatter_limit(t *testing.T) {
+	_, c := newFakeCluster(t, "scatter-limit", 8)
+	shards := c.All()
+	var (
+		mu      sync.Mutex
+		running int
+		max     int
+	)
+	res := c.Scatter(context.Background(), ScatterOptions{Limit: 3}, func(context.Context, Shard) error {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(2 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return nil
+	})
+	if err := res.Err(); err != nil || len(res.Succeeded) != len(shards) {
+		t.Errorf("Scatter() = %v, err = %v", res.Succeeded, err)
+	}
+	if max > 3 {
+		t.Errorf("Scatter() ran %d shards concurrently, want at most 3", max)
+	}
+}
+
+func Test_cluster_Scatter_cancelled(t *testing.T) {
+	_, c := newFakeCluster(t, "scatter-cancelled", 2)
+	shards := c.All()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res := c.Scatter(ctx, ScatterOptions{Limit: 1}, func(context.Context, Shard) error {
+		t.Error("Scatter() ran on a cancelled context")
+		return nil
+	})
+	if len(res.Failed) != len(shards) || !errors.Is(res.Err(), context.Canceled) {
+		t.Errorf("Scatter() failed = %v, err = %v", res.Failed, res.Err())
+	}
+}
+
+func TestShardError(t *testing.T) {
+	boom := errors.New("boom")
+	err := &ShardError{NewShard("000001", &sql.DB{}, false), boom}
+	if got := err.Error(); got != "shard '000001': boom" {
+		t.Errorf("Error() = %v", got)
+	}
+	if !errors.Is(err, boom) {
+		t.Error("errors.Is() = false")
+	}
+}
@@ -1,18 +1,48 @@
 package cluster
 
 import (
+	"context"
 	"database/sql"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
-// NewShard returns a new Shard.
+// NewShard returns a new Shard with the default weight of 1.
 func NewShard(name string, conn *sql.DB, readonly bool) Shard {
-	return &shard{
+	return NewWeightedShard(name, conn, readonly, 1)
+}
+
+// NewWeightedShard returns a new Shard with the given placement weight.
+func NewWeightedShard(name string, conn *sql.DB, readonly bool, weight int) Shard {
+	s := &shard{
 		id:   strings.TrimSpace(name),
 		conn: conn,
-		ro:   readonly,
 	}
+	s.SetReadOnly(readonly)
+	s.SetWeight(weight)
+	return s
+}
+
+// NewReplicatedShard returns a new Shard that writes to primary and reads
+// from one of the replicas chosen by policy. If policy is nil, replicas
+// are used in round-robin order.
+func NewReplicatedShard(name string, primary *sql.DB, replicas []*sql.DB, policy ReplicaPolicy, readonly bool) Shard {
+	if policy == nil {
+		policy = NewRoundRobinReplicaPolicy()
+	}
+	s := &shard{
+		id:   strings.TrimSpace(name),
+		conn: primary,
+		rs:   make([]*sql.DB, len(replicas)),
+		rp:   policy,
+		down: make(map[*sql.DB]struct{}),
+	}
+	copy(s.rs, replicas)
+	s.SetReadOnly(readonly)
+	s.SetWeight(1)
+	s.hr.Store(s.rs)
+	return s
 }
 
 // Shard interface.
@@ -23,30 +53,97 @@ type Shard interface {
 	// ReadOnly returns true if the Shard is in read only mode.
 	ReadOnly() bool
 
+	// SetWeight sets the placement weight of the Shard used by the weighted
+	// Placer. Negative values are treated as 0.
+	SetWeight(int)
+
+	// Weight returns the placement weight of the Shard.
+	Weight() int
+
 	// ID returns the Shard ID.
 	ID() string
 
-	// Conn returns the Shard database connection.
+	// Conn returns the Shard database connection. It is the same as
+	// Writer.
 	Conn() *sql.DB
+
+	// Writer returns the primary database connection.
+	Writer() *sql.DB
+
+	// Reader returns a healthy replica database connection, or the primary
+	// one if there is none.
+	Reader() *sql.DB
+
+	// WriterContext is like Writer, but fails with the context error if ctx
+	// is already done.
+	WriterContext(context.Context) (*sql.DB, error)
+
+	// ReaderContext is like Reader, but fails with the context error if ctx
+	// is already done.
+	ReaderContext(context.Context) (*sql.DB, error)
+
+	// Replicas returns all replica database connections.
+	Replicas() []*sql.DB
+
+	// SetReplicaHealthy marks a replica as healthy or not. Unhealthy
+	// replicas are not returned by Reader.
+	SetReplicaHealthy(*sql.DB, bool)
+
+	// SetHealthy marks the primary database as healthy or not. Unhealthy
+	// Shards do not receive new IDs.
+	SetHealthy(bool)
+
+	// Healthy returns true unless the primary database is marked
+	// unhealthy.
+	Healthy() bool
 }
 
 type shard struct {
-	id     string
-	conn   *sql.DB
-	ro     bool
-	roLock sync.RWMutex
+	id   string
+	conn *sql.DB
+	ro   uint32
+	uh   uint32
+	w    int64
+	rs   []*sql.DB
+	rp   ReplicaPolicy
+	hr   atomic.Value
+	down map[*sql.DB]struct{}
+	mu   sync.Mutex
 }
 
 func (s *shard) SetReadOnly(readonly bool) {
-	s.roLock.Lock()
-	s.ro = readonly
-	s.roLock.Unlock()
+	var ro uint32
+	if readonly {
+		ro = 1
+	}
+	atomic.StoreUint32(&s.ro, ro)
 }
 
 func (s *shard) ReadOnly() bool {
-	s.roLock.RLock()
-	defer s.roLock.RUnlock()
-	return s.ro
+	return atomic.LoadUint32(&s.ro) == 1
+}
+
+func (s *shard) SetHealthy(healthy bool) {
+	var uh uint32
+	if !healthy {
+		uh = 1
+	}
+	atomic.StoreUint32(&s.uh, uh)
+}
+
+func (s *shard) Healthy() bool {
+	return atomic.LoadUint32(&s.uh) == 0
+}
+
+func (s *shard) SetWeight(weight int) {
+	if weight < 0 {
+		weight = 0
+	}
+	atomic.StoreInt64(&s.w, int64(weight))
+}
+
+func (s *shard) Weight() int {
+	return int(atomic.LoadInt64(&s.w))
 }
 
 func (s *shard) ID() string {
@@ -56,3 +153,64 @@ func (s *shard) ID() string {
 func (s *shard) Conn() *sql.DB {
 	return s.conn
 }
+
+func (s *shard) Writer() *sql.DB {
+	return s.conn
+}
+
+func (s *shard) Reader() *sql.DB {
+	hr, _ := s.hr.Load().([]*sql.DB)
+	if len(hr) == 0 {
+		return s.conn
+	}
+	if db := s.rp.Pick(hr); db != nil {
+		return db
+	}
+	return s.conn
+}
+
+func (s *shard) WriterContext(ctx context.Context) (*sql.DB, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Writer(), nil
+}
+
+func (s *shard) ReaderContext(ctx context.Context) (*sql.DB, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.Reader(), nil
+}
+
+func (s *shard) Replicas() []*sql.DB {
+	res := make([]*sql.DB, len(s.rs))
+	copy(res, s.rs)
+	return res
+}
+
+// SetReplicaHealthy rebuilds the snapshot of healthy replicas read by
+// Reader. Health changes are rare, so the copy is cheap.
+func (s *shard) SetReplicaHealthy(db *sql.DB, healthy bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.down == nil {
+		return
+	}
+	_, isDown := s.down[db]
+	if healthy == !isDown {
+		return
+	}
+	if healthy {
+		delete(s.down, db)
+	} else {
+		s.down[db] = struct{}{}
+	}
+	hr := make([]*sql.DB, 0, len(s.rs))
+	for _, r := range s.rs {
+		if _, isDown := s.down[r]; !isDown {
+			hr = append(hr, r)
+		}
+	}
+	s.hr.Store(hr)
+}
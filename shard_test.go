@@ -1,6 +1,7 @@
 package cluster
 
 import (
+	"context"
 	"database/sql"
 	"reflect"
 	"testing"
@@ -20,7 +21,7 @@ func TestNewShard(t *testing.T) {
 		{
 			"",
 			args{"", &sql.DB{}, false},
-			&shard{id: "", conn: &sql.DB{}, ro: false},
+			&shard{id: "", conn: &sql.DB{}, ro: 0, w: 1},
 		},
 	}
 	for _, tt := range tests {
@@ -32,6 +33,26 @@ func TestNewShard(t *testing.T) {
 	}
 }
 
+func TestNewWeightedShard(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight int
+		want   int
+	}{
+		{"zero", 0, 0},
+		{"one", 1, 1},
+		{"ten", 10, 10},
+		{"negative", -1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewWeightedShard("000001", &sql.DB{}, false, tt.weight).Weight(); got != tt.want {
+				t.Errorf("Weight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func Test_shard_Conn(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -94,7 +115,7 @@ func Test_shard_ReadOnly(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &shard{
-				ro: tt.ro,
+				ro: flag(tt.ro),
 			}
 			if got := s.ReadOnly(); got != tt.want {
 				t.Errorf("ReadOnly() = %v, want %v", got, tt.want)
@@ -122,9 +143,136 @@ func Test_shard_SetReadOnly(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &shard{}
 			s.SetReadOnly(tt.ro)
-			if got := s.ro; got != tt.want {
+			if got := s.ro; got != flag(tt.want) {
 				t.Errorf("SetReadOnly() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_shard_SetWeight(t *testing.T) {
+	tests := []struct {
+		name   string
+		weight int
+		want   int64
+	}{
+		{"zero", 0, 0},
+		{"one", 1, 1},
+		{"hundred", 100, 100},
+		{"negative", -5, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &shard{w: 1}
+			s.SetWeight(tt.weight)
+			if got := s.w; got != tt.want {
+				t.Errorf("SetWeight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func flag(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestNewReplicatedShard(t *testing.T) {
+	primary := &sql.DB{}
+	replicas := []*sql.DB{{}, {}}
+	s := NewReplicatedShard(" 000001 ", primary, replicas, nil, true)
+	if s.ID() != "000001" || !s.ReadOnly() || s.Weight() != 1 {
+		t.Errorf("NewReplicatedShard() = %v, readonly %v, weight %v", s.ID(), s.ReadOnly(), s.Weight())
+	}
+	if s.Conn() != primary || s.Writer() != primary {
+		t.Errorf("Writer() = %p, want %p", s.Writer(), primary)
+	}
+	if got := s.Replicas(); len(got) != 2 || got[0] != replicas[0] || got[1] != replicas[1] {
+		t.Errorf("Replicas() = %v, want %v", got, replicas)
+	}
+	replicas[0] = nil
+	if s.Replicas()[0] == nil {
+		t.Error("Replicas() shares the caller slice")
+	}
+}
+
+func Test_shard_Reader(t *testing.T) {
+	primary := &sql.DB{}
+	replicas := []*sql.DB{{}, {}}
+	s := NewReplicatedShard("000001", primary, replicas, NewRoundRobinReplicaPolicy(), false)
+	tests := []struct {
+		name    string
+		healthy []bool
+		want    []*sql.DB
+	}{
+		{"all healthy", []bool{true, true}, []*sql.DB{replicas[0], replicas[1], replicas[0]}},
+		{"one down", []bool{false, true}, []*sql.DB{replicas[1], replicas[1]}},
+		{"all down", []bool{false, false}, []*sql.DB{primary, primary}},
+		{"recovered", []bool{true, false}, []*sql.DB{replicas[0], replicas[0]}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i, healthy := range tt.healthy {
+				s.SetReplicaHealthy(replicas[i], healthy)
+			}
+			for i, want := range tt.want {
+				if got := s.Reader(); got != want {
+					t.Errorf("Reader() #%d = %p, want %p", i, got, want)
+				}
+			}
+		})
+	}
+	plain := NewShard("000002", primary, false)
+	plain.SetReplicaHealthy(primary, false)
+	if got := plain.Reader(); got != primary {
+		t.Errorf("Reader() = %p, want %p", got, primary)
+	}
+	if got := plain.Replicas(); len(got) != 0 {
+		t.Errorf("Replicas() = %v, want none", got)
+	}
+}
+
+func Test_shard_SetHealthy(t *testing.T) {
+	tests := []struct {
+		name    string
+		healthy bool
+		want    uint32
+	}{
+		{"unhealthy", false, 1},
+		{"healthy", true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &shard{}
+			s.SetHealthy(tt.healthy)
+			if got := s.uh; got != tt.want {
+				t.Errorf("SetHealthy() = %v, want %v", got, tt.want)
+			}
+			if got := s.Healthy(); got != tt.healthy {
+				t.Errorf("Healthy() = %v, want %v", got, tt.healthy)
+			}
+		})
+	}
+}
+
+func Test_shard_ReaderContext(t *testing.T) {
+	primary := &sql.DB{}
+	replica := &sql.DB{}
+	s := NewReplicatedShard("000001", primary, []*sql.DB{replica}, nil, false)
+	if db, err := s.ReaderContext(context.Background()); err != nil || db != replica {
+		t.Errorf("ReaderContext() = %p, %v, want %p", db, err, replica)
+	}
+	if db, err := s.WriterContext(context.Background()); err != nil || db != primary {
+		t.Errorf("WriterContext() = %p, %v, want %p", db, err, primary)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.ReaderContext(ctx); err != context.Canceled {
+		t.Errorf("ReaderContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := s.WriterContext(ctx); err != context.Canceled {
+		t.Errorf("WriterContext() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+// signedTagSize is the number of bytes of the HMAC kept in a signed ID.
+const signedTagSize = 12
+
+// NewSignedCombiner returns a Combiner appending an HMAC-SHA256 tag to the
+// IDs combined by com, so IDs cannot be forged or enumerated without the
+// key. The first key signs new IDs, all keys are accepted when extracting,
+// which allows rotating keys: add the new key first, and drop the old one
+// once the IDs signed with it are no longer in use.
+func NewSignedCombiner(com Combiner, keys ...[]byte) (Combiner, error) {
+	if com == nil {
+		return nil, cErr("combiner cannot be nil")
+	}
+	if len(keys) == 0 {
+		return nil, cErr("at least one key is required")
+	}
+	c := &signedCombiner{com: com, keys: make([][]byte, len(keys))}
+	for i, key := range keys {
+		if len(key) == 0 {
+			return nil, cErr("key is empty")
+		}
+		c.keys[i] = make([]byte, len(key))
+		copy(c.keys[i], key)
+	}
+	return c, nil
+}
+
+type signedCombiner struct {
+	com  Combiner
+	keys [][]byte
+}
+
+func (c *signedCombiner) Validate(shardId string) bool {
+	return c.com.Validate(shardId)
+}
+
+func (c *signedCombiner) Combine(id string, shardId string) string {
+	v := c.com.Combine(id, shardId)
+	if v == "" {
+		return ""
+	}
+	return v + "." + base64.RawURLEncoding.EncodeToString(c.sign(c.keys[0], v))
+}
+
+// Extract fails with ErrIdParseFailed if the tag does not match any key.
+func (c *signedCombiner) Extract(id string) (string, string, error) {
+	id = strings.TrimSpace(id)
+	i := strings.LastIndexByte(id, '.')
+	if i == -1 {
+		return "", "", ErrIdParseFailed
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(id[i+1:])
+	if err != nil || len(tag) != signedTagSize {
+		return "", "", ErrIdParseFailed
+	}
+	for _, key := range c.keys {
+		if hmac.Equal(tag, c.sign(key, id[:i])) {
+			return c.com.Extract(id[:i])
+		}
+	}
+	return "", "", ErrIdParseFailed
+}
+
+// sign returns the truncated tag of v. The input is prefixed so tags cannot
+// be mixed up with other HMACs made with the same key, such as cursors.
+func (c *signedCombiner) sign(key []byte, v string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte("cluster-id:"))
+	m.Write([]byte(v))
+	return m.Sum(nil)[:signedTagSize]
+}
@@ -0,0 +1,111 @@
+package cluster
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestNewSignedCombiner(t *testing.T) {
+	tests := []struct {
+		name    string
+		com     Combiner
+		keys    [][]byte
+		wantErr bool
+	}{
+		{"ok", defaultCombiner, [][]byte{[]byte("k1")}, false},
+		{"rotation", defaultCombiner, [][]byte{[]byte("k2"), []byte("k1")}, false},
+		{"no combiner", nil, [][]byte{[]byte("k1")}, true},
+		{"no keys", defaultCombiner, nil, true},
+		{"empty key", defaultCombiner, [][]byte{[]byte("k1"), {}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSignedCombiner(tt.com, tt.keys...); (err != nil) != tt.wantErr {
+				t.Errorf("NewSignedCombiner() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_signedCombiner_Extract(t *testing.T) {
+	old, err := NewSignedCombiner(defaultCombiner, []byte("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := NewSignedCombiner(defaultCombiner, []byte("k2"), []byte("k1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	renewed, err := NewSignedCombiner(defaultCombiner, []byte("k2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signedOld := old.Combine("100", "000001")
+	signedNew := rotated.Combine("100", "000001")
+	if signedOld == signedNew {
+		t.Fatalf("Combine() = %v with both keys", signedOld)
+	}
+	if !strings.HasPrefix(signedNew, "100@000001.") {
+		t.Errorf("Combine() = %v, want the combined id followed by a tag", signedNew)
+	}
+	forged := "100@000002" + signedNew[len("100@000001"):]
+	tampered := []byte(signedNew)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name    string
+		c       Combiner
+		id      string
+		wantErr bool
+	}{
+		{"old key", old, signedOld, false},
+		{"rotated, old key", rotated, signedOld, false},
+		{"rotated, new key", rotated, signedNew, false},
+		{"renewed, new key", renewed, signedNew, false},
+		{"renewed, old key", renewed, signedOld, true},
+		{"unsigned", rotated, "100@000001", true},
+		{"other shard", rotated, forged, true},
+		{"tampered tag", rotated, string(tampered), true},
+		{"short tag", rotated, signedNew[:len(signedNew)-2], true},
+		{"empty", rotated, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, shardId, err := tt.c.Extract(tt.id)
+			if tt.wantErr {
+				if err != ErrIdParseFailed {
+					t.Errorf("Extract() error = %v, want %v", err, ErrIdParseFailed)
+				}
+				return
+			}
+			if err != nil || id != "100" || shardId != "000001" {
+				t.Errorf("Extract() = %v, %v, %v, want 100, 000001", id, shardId, err)
+			}
+		})
+	}
+}
+
+func Test_signedCombiner_cluster(t *testing.T) {
+	com, err := NewSignedCombiner(defaultCombiner, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewShard("000001", &sql.DB{}, false)
+	if _, err := NewCluster(testIdGen, com, nil, NewShard("00001", &sql.DB{}, false)); err == nil {
+		t.Error("NewCluster() accepted a shard id the inner combiner rejects")
+	}
+	c, err := NewCluster(testIdGen, com, nil, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := c.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.One(id); err != nil || got != s {
+		t.Errorf("One() = %v, %v", got, err)
+	}
+	if _, err := c.One(id[:strings.LastIndexByte(id, '.')]); err != ErrIdParseFailed {
+		t.Errorf("One() error = %v for an unsigned id, want %v", err, ErrIdParseFailed)
+	}
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SnowflakeOptions configure a Snowflake generator. The zero value of every
+// field selects its default.
+type SnowflakeOptions struct {
+	// Epoch the timestamps are counted from. Defaults to 2020-01-01 UTC.
+	Epoch time.Time
+
+	// Node is the ID of the generator, unique among the processes
+	// generating IDs for the same entities.
+	Node int64
+
+	// NodeBits and SequenceBits are the number of bits of the node ID and
+	// of the per millisecond sequence. The timestamp gets the remaining
+	// bits of a positive int64. Default to 10 and 12, i.e. 1024 nodes of
+	// 4096 IDs per millisecond for 69 years.
+	NodeBits     uint
+	SequenceBits uint
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+}
+
+// NewSnowflake returns a Snowflake generator. IDs are made of a timestamp
+// in milliseconds since the epoch, the node ID and a sequence, from the
+// highest bits to the lowest, and formatted as decimal strings.
+func NewSnowflake(opts SnowflakeOptions) (Snowflake, error) {
+	if opts.Epoch.IsZero() {
+		opts.Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if opts.NodeBits == 0 && opts.SequenceBits == 0 {
+		opts.NodeBits, opts.SequenceBits = 10, 12
+	}
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	if opts.SequenceBits == 0 || opts.NodeBits+opts.SequenceBits > 31 {
+		return nil, cErr("invalid snowflake bit layout")
+	}
+	if opts.Node < 0 || opts.Node >= 1<<opts.NodeBits {
+		return nil, cErr("snowflake node " + strconv.FormatInt(opts.Node, 10) + " out of range")
+	}
+	if opts.Clock().Before(opts.Epoch) {
+		return nil, cErr("snowflake epoch is in the future")
+	}
+	return &snowflake{
+		opts:      opts,
+		epoch:     opts.Epoch.UnixNano() / int64(time.Millisecond),
+		maxTs:     1<<(63-opts.NodeBits-opts.SequenceBits) - 1,
+		maxSeq:    1<<opts.SequenceBits - 1,
+		nodeShift: opts.SequenceBits,
+		tsShift:   opts.NodeBits + opts.SequenceBits,
+		last:      -1,
+	}, nil
+}
+
+// Snowflake generates time ordered int64 IDs. It is safe for concurrent
+// use.
+//
+// When the sequence of a millisecond is exhausted, the generator waits for
+// the next one. When the clock goes backwards, it keeps counting from the
+// last timestamp it used, so IDs stay unique and increasing, and moves the
+// timestamp forward by itself if needed until the clock catches up.
+type Snowflake interface {
+	Generator
+	GeneratorContext
+
+	// NextID returns a new ID. It fails if ctx is done while waiting for
+	// the next millisecond or if the timestamp no longer fits its bits.
+	NextID(ctx context.Context) (int64, error)
+
+	// Decompose returns the time, node and sequence of id.
+	Decompose(id int64) (time.Time, int64, int64)
+}
+
+type snowflake struct {
+	opts      SnowflakeOptions
+	epoch     int64
+	maxTs     int64
+	maxSeq    int64
+	nodeShift uint
+	tsShift   uint
+	last      int64
+	seq       int64
+	mu        sync.Mutex
+}
+
+// Generate panics if NextID fails, which can only happen once the
+// timestamp bits are exhausted.
+func (g *snowflake) Generate() string {
+	id, err := g.GenerateContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (g *snowflake) GenerateContext(ctx context.Context) (string, error) {
+	id, err := g.NextID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(id, 10), nil
+}
+
+func (g *snowflake) NextID(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	switch {
+	case now > g.last:
+		g.last, g.seq = now, 0
+	case g.seq < g.maxSeq:
+		g.seq++
+	case now < g.last:
+		g.last, g.seq = g.last+1, 0
+	default:
+		for now <= g.last {
+			t := time.NewTimer(time.Duration(g.last-now+1) * time.Millisecond)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return 0, ctx.Err()
+			case <-t.C:
+			}
+			now = g.now()
+		}
+		g.last, g.seq = now, 0
+	}
+	if g.last > g.maxTs {
+		return 0, cErr("snowflake timestamp overflow")
+	}
+	return g.last<<g.tsShift | g.opts.Node<<g.nodeShift | g.seq, nil
+}
+
+func (g *snowflake) Decompose(id int64) (time.Time, int64, int64) {
+	ms := g.epoch + id>>g.tsShift
+	t := time.Unix(0, ms*int64(time.Millisecond))
+	node := id >> g.nodeShift & (1<<g.opts.NodeBits - 1)
+	return t, node, id & g.maxSeq
+}
+
+// now returns the milliseconds elapsed since the epoch.
+func (g *snowflake) now() int64 {
+	return g.opts.Clock().UnixNano()/int64(time.Millisecond) - g.epoch
+}
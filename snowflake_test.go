@@ -0,0 +1,169 @@
+package cluster
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock is a manually driven clock.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+var testEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestNewSnowflake(t *testing.T) {
+	clock := &testClock{now: testEpoch.Add(time.Hour)}
+	tests := []struct {
+		name    string
+		opts    SnowflakeOptions
+		wantErr bool
+	}{
+		{"defaults", SnowflakeOptions{}, false},
+		{"layout", SnowflakeOptions{NodeBits: 4, SequenceBits: 8, Node: 15}, false},
+		{"node out of range", SnowflakeOptions{NodeBits: 4, SequenceBits: 8, Node: 16}, true},
+		{"negative node", SnowflakeOptions{Node: -1}, true},
+		{"no sequence", SnowflakeOptions{NodeBits: 4}, true},
+		{"too many bits", SnowflakeOptions{NodeBits: 16, SequenceBits: 16}, true},
+		{"future epoch", SnowflakeOptions{Epoch: testEpoch.Add(2 * time.Hour), Clock: clock.Now}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSnowflake(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("NewSnowflake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_snowflake_NextID(t *testing.T) {
+	clock := &testClock{now: testEpoch.Add(time.Second)}
+	g, err := NewSnowflake(SnowflakeOptions{Node: 5, NodeBits: 4, SequenceBits: 2, Clock: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	type part struct {
+		ms   int64
+		node int64
+		seq  int64
+	}
+	next := func() part {
+		t.Helper()
+		id, err := g.NextID(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts, node, seq := g.Decompose(id)
+		return part{ts.Sub(testEpoch).Milliseconds(), node, seq}
+	}
+	steps := []struct {
+		name    string
+		advance time.Duration
+		want    part
+	}{
+		{"first", 0, part{1000, 5, 0}},
+		{"same millisecond", 0, part{1000, 5, 1}},
+		{"next millisecond", time.Millisecond, part{1001, 5, 0}},
+		{"clock backwards", -10 * time.Millisecond, part{1001, 5, 1}},
+		{"still backwards", 0, part{1001, 5, 2}},
+		{"still backwards", 0, part{1001, 5, 3}},
+		{"borrowed millisecond", 0, part{1002, 5, 0}},
+		{"clock caught up", 20 * time.Millisecond, part{1011, 5, 0}},
+	}
+	for _, s := range steps {
+		clock.Add(s.advance)
+		if got := next(); got != s.want {
+			t.Errorf("%s: NextID() = %+v, want %+v", s.name, got, s.want)
+		}
+	}
+}
+
+func Test_snowflake_exhausted(t *testing.T) {
+	clock := &testClock{now: testEpoch.Add(time.Second)}
+	g, err := NewSnowflake(SnowflakeOptions{NodeBits: 1, SequenceBits: 1, Clock: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if _, err := g.NextID(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := g.NextID(cancelled); err != context.Canceled {
+		t.Errorf("NextID() error = %v, want %v", err, context.Canceled)
+	}
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		clock.Add(time.Millisecond)
+	}()
+	id, err := g.NextID(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, _, seq := g.Decompose(id); ts.Sub(testEpoch) != 1001*time.Millisecond || seq != 0 {
+		t.Errorf("NextID() = %v, %v, want the next millisecond", ts, seq)
+	}
+}
+
+func Test_snowflake_overflow(t *testing.T) {
+	clock := &testClock{now: testEpoch.Add(time.Second)}
+	g, err := NewSnowflake(SnowflakeOptions{NodeBits: 16, SequenceBits: 15, Clock: clock.Now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Add(1 << 32 * time.Millisecond)
+	if _, err := g.GenerateContext(context.Background()); err == nil {
+		t.Error("GenerateContext() error = nil after the timestamp overflowed")
+	}
+}
+
+func Test_snowflake_concurrent(t *testing.T) {
+	g, err := NewSnowflake(SnowflakeOptions{Node: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers, n = 8, 2000
+	ids := make(chan string, workers*n)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				ids <- g.Generate()
+			}
+		}()
+	}
+	wg.Wait()
+	close(ids)
+	seen := make(map[string]struct{}, workers*n)
+	for id := range ids {
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			t.Fatalf("Generate() = %q, not a number", id)
+		}
+		if _, exists := seen[id]; exists {
+			t.Fatalf("Generate() returned %v twice", id)
+		}
+		seen[id] = struct{}{}
+	}
+}
@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"sync"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// TimeIDOptions configure the ULID and UUIDv7 generators.
+type TimeIDOptions struct {
+	// Monotonic makes IDs generated within the same millisecond increase
+	// by incrementing the random part of the previous ID instead of
+	// drawing a new one. It also keeps IDs increasing if the clock goes
+	// backwards.
+	Monotonic bool
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+
+	// Entropy is the source of the random bits. Defaults to crypto/rand.
+	Entropy io.Reader
+}
+
+// NewULID returns a Generator of ULIDs: a 48 bit millisecond timestamp
+// followed by 80 random bits, encoded as 26 Crockford base32 characters.
+// Generate panics if the entropy source fails or, in monotonic mode, if
+// the random bits overflow within a millisecond; Cluster uses
+// GenerateContext, which returns these errors instead. It is safe for
+// concurrent use.
+func NewULID(opts TimeIDOptions) Generator {
+	return newTimeIDGen(opts, 80, formatULID)
+}
+
+// NewUUIDv7 returns a Generator of version 7 UUIDs as defined by RFC 9562:
+// a 48 bit millisecond timestamp followed by 74 random bits, in the
+// canonical lowercase form. It behaves like the ULID Generator otherwise.
+func NewUUIDv7(opts TimeIDOptions) Generator {
+	return newTimeIDGen(opts, 74, formatUUIDv7)
+}
+
+func newTimeIDGen(opts TimeIDOptions, bits uint, format func(int64, uint64, uint64) string) *timeIDGen {
+	if opts.Clock == nil {
+		opts.Clock = time.Now
+	}
+	if opts.Entropy == nil {
+		opts.Entropy = rand.Reader
+	}
+	return &timeIDGen{opts: opts, mask: 1<<(bits-64) - 1, format: format, last: -1}
+}
+
+// timeIDGen generates IDs made of a millisecond timestamp and random bits.
+// The random bits are kept as a 128 bit number, hi holding the bits above
+// the lowest 64 ones.
+type timeIDGen struct {
+	opts   TimeIDOptions
+	mask   uint64
+	format func(ms int64, hi, lo uint64) string
+	last   int64
+	hi, lo uint64
+	buf    [16]byte
+	mu     sync.Mutex
+}
+
+func (g *timeIDGen) Generate() string {
+	id, err := g.GenerateContext(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func (g *timeIDGen) GenerateContext(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ms := g.opts.Clock().UnixNano() / int64(time.Millisecond)
+	if ms < 0 || ms >= 1<<48 {
+		return "", cErr("time out of range")
+	}
+	if g.opts.Monotonic && ms <= g.last {
+		g.lo++
+		if g.lo == 0 {
+			g.hi++
+		}
+		if g.hi > g.mask {
+			return "", cErr("random bits exhausted within a millisecond")
+		}
+		return g.format(g.last, g.hi, g.lo), nil
+	}
+	if _, err := io.ReadFull(g.opts.Entropy, g.buf[:]); err != nil {
+		return "", wrapErr(err, "failed to read entropy")
+	}
+	g.hi = binary.BigEndian.Uint64(g.buf[:8]) & g.mask
+	g.lo = binary.BigEndian.Uint64(g.buf[8:])
+	g.last = ms
+	return g.format(ms, g.hi, g.lo), nil
+}
+
+func formatULID(ms int64, hi, lo uint64) string {
+	hi |= uint64(ms) << 16
+	var b [26]byte
+	for i := range b {
+		shift := uint(5 * (len(b) - 1 - i))
+		var v uint64
+		switch {
+		case shift >= 64:
+			v = hi >> (shift - 64)
+		case shift > 59:
+			v = lo>>shift | hi<<(64-shift)
+		default:
+			v = lo >> shift
+		}
+		b[i] = crockford[v&31]
+	}
+	return string(b[:])
+}
+
+func formatUUIDv7(ms int64, hi, lo uint64) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(ms)<<16|0x7000|(hi<<2|lo>>62)&0xfff)
+	binary.BigEndian.PutUint64(b[8:], 0x8000000000000000|lo&(1<<62-1))
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
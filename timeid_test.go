@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fixedEntropy returns the same bytes on every read.
+type fixedEntropy []byte
+
+func (e fixedEntropy) Read(p []byte) (int, error) {
+	return copy(p, e), nil
+}
+
+type failingEntropy struct{}
+
+func (failingEntropy) Read([]byte) (int, error) {
+	return 0, errors.New("boom")
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestNewULID(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 1469918176385*int64(time.Millisecond))}
+	entropy := fixedEntropy(mustHex("000102030405060708090a0b0c0d0e0f"))
+	tests := []struct {
+		name      string
+		monotonic bool
+		advance   time.Duration
+		want      string
+	}{
+		{"first", true, 0, "01ARYZ6S410R3GG28A1C60T3GF"},
+		{"same millisecond", true, 0, "01ARYZ6S410R3GG28A1C60T3GG"},
+		{"clock backwards", true, -time.Second, "01ARYZ6S410R3GG28A1C60T3GH"},
+		{"next millisecond", true, time.Second + time.Millisecond, "01ARYZ6S420R3GG28A1C60T3GF"},
+	}
+	g := NewULID(TimeIDOptions{Monotonic: true, Clock: clock.Now, Entropy: entropy})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Add(tt.advance)
+			if got := g.Generate(); got != tt.want {
+				t.Errorf("Generate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	g = NewULID(TimeIDOptions{Clock: clock.Now, Entropy: entropy})
+	if a, b := g.Generate(), g.Generate(); a != b {
+		t.Errorf("Generate() = %v, %v, want the entropy to be drawn again", a, b)
+	}
+}
+
+func TestNewUUIDv7(t *testing.T) {
+	clock := &testClock{now: time.Unix(0, 0x017F22E279B0*int64(time.Millisecond))}
+	g := NewUUIDv7(TimeIDOptions{
+		Monotonic: true,
+		Clock:     clock.Now,
+		Entropy:   fixedEntropy(mustHex("0000000000000330d8c4dc0c0c07398f")),
+	})
+	for _, want := range []string{
+		"017f22e2-79b0-7cc3-98c4-dc0c0c07398f",
+		"017f22e2-79b0-7cc3-98c4-dc0c0c073990",
+	} {
+		if got := g.Generate(); got != want {
+			t.Errorf("Generate() = %v, want %v", got, want)
+		}
+	}
+	clock.now = time.Unix(0, 1469918176385*int64(time.Millisecond))
+	g = NewUUIDv7(TimeIDOptions{Clock: clock.Now, Entropy: fixedEntropy(mustHex("000102030405060708090a0b0c0d0e0f"))})
+	if got, want := g.Generate(), "01563df3-6481-781c-8809-0a0b0c0d0e0f"; got != want {
+		t.Errorf("Generate() = %v, want %v", got, want)
+	}
+}
+
+func Test_timeIDGen_errors(t *testing.T) {
+	clock := &testClock{now: time.Unix(1000, 0)}
+	ctx := context.Background()
+	g := NewULID(TimeIDOptions{Clock: clock.Now, Entropy: failingEntropy{}}).(GeneratorContext)
+	if _, err := g.GenerateContext(ctx); err == nil {
+		t.Error("GenerateContext() error = nil with a failing entropy source")
+	}
+	g = NewUUIDv7(TimeIDOptions{
+		Monotonic: true,
+		Clock:     clock.Now,
+		Entropy:   fixedEntropy(mustHex("00000000000003ffffffffffffffffff")),
+	}).(GeneratorContext)
+	if _, err := g.GenerateContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.GenerateContext(ctx); err == nil {
+		t.Error("GenerateContext() error = nil after the random bits overflowed")
+	}
+}
+
+func Test_timeIDGen_sorted(t *testing.T) {
+	for name, g := range map[string]Generator{
+		"ulid":   NewULID(TimeIDOptions{Monotonic: true}),
+		"uuidv7": NewUUIDv7(TimeIDOptions{Monotonic: true}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			ids := make([]string, 1000)
+			for i := range ids {
+				ids[i] = g.Generate()
+			}
+			if !sort.StringsAreSorted(ids) {
+				t.Error("Generate() returned unsorted ids")
+			}
+			for i := 1; i < len(ids); i++ {
+				if ids[i] == ids[i-1] {
+					t.Fatalf("Generate() returned %v twice", ids[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// TxFunc is run inside a transaction by WithTx.
+type TxFunc func(*sql.Tx) error
+
+// TxOptions control how WithTx runs a transaction.
+type TxOptions struct {
+	// Isolation level of the transaction.
+	Isolation sql.IsolationLevel
+
+	// ReadOnly starts a read only transaction.
+	ReadOnly bool
+
+	// MaxRetries is the number of times a transaction failing with a
+	// retryable error is run again.
+	MaxRetries int
+
+	// Retryable reports whether a failed transaction may be run again. If
+	// nil, IsSerializationError is used.
+	Retryable func(error) bool
+}
+
+// IsSerializationError reports whether err is a serialization failure or
+// a deadlock, after which a transaction can safely be run again. It
+// recognises errors exposing a SQLSTATE through a SQLState() method and
+// falls back to the PostgreSQL and MySQL error messages.
+func IsSerializationError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var se interface{ SQLState() string }
+	if errors.As(err, &se) {
+		switch se.SQLState() {
+		case "40001", "40P01":
+			return true
+		}
+		return false
+	}
+	msg := err.Error()
+	for _, s := range []string{
+		"40001",
+		"40P01",
+		"could not serialize access",
+		"Error 1213",
+		"Deadlock found",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTx runs fn in a transaction on db, committing on success and rolling
+// back on error or panic. Retryable failures, including failed commits,
+// run fn again in a new transaction.
+func withTx(ctx context.Context, db *sql.DB, opts *TxOptions, fn TxFunc) error {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = IsSerializationError
+	}
+	txo := &sql.TxOptions{Isolation: opts.Isolation, ReadOnly: opts.ReadOnly}
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, txo, fn)
+		if err == nil || attempt >= opts.MaxRetries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+func runTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions, fn TxFunc) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
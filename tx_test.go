@@ -0,0 +1,162 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type sqlStateErr string
+
+func (e sqlStateErr) Error() string {
+	return "sql error " + string(e)
+}
+
+func (e sqlStateErr) SQLState() string {
+	return string(e)
+}
+
+func Test_cluster_WithTx(t *testing.T) {
+	boom := errors.New("boom")
+	conflict := sqlStateErr("40001")
+	tests := []struct {
+		name     string
+		opts     *TxOptions
+		failures []error
+		commit   error
+		wantErr  error
+		want     []string
+	}{
+		{"commit", nil, nil, nil, nil, []string{"BEGIN", "UPDATE", "COMMIT"}},
+		{"rollback", nil, []error{boom}, nil, boom, []string{"BEGIN", "UPDATE", "ROLLBACK"}},
+		{
+			"no retries by default",
+			nil,
+			[]error{conflict},
+			nil,
+			conflict,
+			[]string{"BEGIN", "UPDATE", "ROLLBACK"},
+		},
+		{
+			"retry",
+			&TxOptions{MaxRetries: 2},
+			[]error{conflict, conflict},
+			nil,
+			nil,
+			[]string{"BEGIN", "UPDATE", "ROLLBACK", "BEGIN", "UPDATE", "ROLLBACK", "BEGIN", "UPDATE", "COMMIT"},
+		},
+		{
+			"retries exhausted",
+			&TxOptions{MaxRetries: 1},
+			[]error{conflict, conflict},
+			nil,
+			conflict,
+			[]string{"BEGIN", "UPDATE", "ROLLBACK", "BEGIN", "UPDATE", "ROLLBACK"},
+		},
+		{
+			"not retryable",
+			&TxOptions{MaxRetries: 3},
+			[]error{boom},
+			nil,
+			boom,
+			[]string{"BEGIN", "UPDATE", "ROLLBACK"},
+		},
+		{
+			"custom retryable",
+			&TxOptions{MaxRetries: 1, Retryable: func(err error) bool { return err == boom }},
+			[]error{boom},
+			nil,
+			nil,
+			[]string{"BEGIN", "UPDATE", "ROLLBACK", "BEGIN", "UPDATE", "COMMIT"},
+		},
+		{
+			"commit failure",
+			&TxOptions{MaxRetries: 1},
+			nil,
+			conflict,
+			conflict,
+			[]string{"BEGIN", "UPDATE", "COMMIT", "BEGIN", "UPDATE", "COMMIT"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, c := newFakeCluster(t, "tx-"+tt.name, 1)
+			f := fs[0]
+			f.commit = func() error { return tt.commit }
+			n := 0
+			err := c.WithTx(context.Background(), "1@000001", tt.opts, func(tx *sql.Tx) error {
+				if _, err := tx.Exec("UPDATE"); err != nil {
+					return err
+				}
+				n++
+				if n <= len(tt.failures) {
+					return tt.failures[n-1]
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("WithTx() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := f.statements(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WithTx() statements = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_cluster_WithTx_panic(t *testing.T) {
+	fs, c := newFakeCluster(t, "tx-panic", 1)
+	f := fs[0]
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("WithTx() panic = %v, want boom", p)
+		}
+		if got := f.statements(); !reflect.DeepEqual(got, []string{"BEGIN", "ROLLBACK"}) {
+			t.Errorf("WithTx() statements = %v", got)
+		}
+	}()
+	_ = c.WithTx(context.Background(), "1@000001", nil, func(*sql.Tx) error {
+		panic("boom")
+	})
+}
+
+func Test_cluster_WithTx_routing(t *testing.T) {
+	fs, c := newFakeCluster(t, "tx-routing", 1)
+	f := fs[0]
+	err := c.WithTx(context.Background(), "1@000002", nil, func(*sql.Tx) error {
+		t.Error("WithTx() ran on an unknown shard")
+		return nil
+	})
+	if err != ErrShardNotFound {
+		t.Errorf("WithTx() error = %v, want %v", err, ErrShardNotFound)
+	}
+	if got := f.statements(); len(got) != 0 {
+		t.Errorf("WithTx() statements = %v", got)
+	}
+}
+
+func TestIsSerializationError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"sqlstate serialization", sqlStateErr("40001"), true},
+		{"sqlstate deadlock", sqlStateErr("40P01"), true},
+		{"sqlstate other", sqlStateErr("23505"), false},
+		{"wrapped sqlstate", &ShardError{NewShard("000001", &sql.DB{}, false), sqlStateErr("40001")}, true},
+		{"postgres message", errors.New("ERROR: could not serialize access due to concurrent update"), true},
+		{"mysql message", errors.New("Error 1213: Deadlock found when trying to get lock"), true},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsSerializationError(tt.err); got != tt.want {
+				t.Errorf("IsSerializationError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
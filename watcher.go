@@ -0,0 +1,290 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewWatcher returns a Watcher that applies changes of the topology file at
+// path to c, checking the file every interval. cfg is the Config c was
+// built from, against which the first reload detects changed connections;
+// if it is nil, connections of existing shards are only compared from the
+// first reload on. Removed and replaced shards are closed after grace, so
+// requests that picked them up just before the change can finish. A
+// non-positive interval defaults to 10 seconds, a non-positive grace to 30
+// seconds. The separator, pattern and placement of the file are not
+// applied, as they cannot change on a live Cluster.
+func NewWatcher(c Cluster, cfg *Config, path string, interval, grace time.Duration) Watcher {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	if grace <= 0 {
+		grace = 30 * time.Second
+	}
+	w := &watcher{
+		c:        c,
+		path:     path,
+		interval: interval,
+		grace:    grace,
+		conf:     make(map[string]ShardConfig),
+	}
+	if cfg != nil {
+		for _, sc := range cfg.Shards {
+			w.conf[sc.ID] = sc
+		}
+	}
+	return w
+}
+
+// Watcher interface.
+type Watcher interface {
+	// Subscribe registers a handler that receives every Event. Handlers are
+	// called synchronously, in the order they were registered.
+	Subscribe(func(Event))
+
+	// Reload reads the topology file and applies it to the Cluster,
+	// regardless of whether the file has changed.
+	Reload() error
+
+	// Run checks the topology file for changes until ctx is done, starting
+	// with an immediate check. Reload errors are reported as ReloadFailed
+	// events.
+	Run(ctx context.Context)
+}
+
+// Event is a topology change reported by a Watcher. It is one of
+// ShardAdded, ShardRemoved, ShardStateChanged or ReloadFailed.
+type Event interface {
+	event()
+}
+
+// ShardAdded is reported when a Shard was added to the Cluster.
+type ShardAdded struct {
+	Shard Shard
+}
+
+// ShardRemoved is reported when a Shard was removed from the Cluster. Its
+// connections are closed once the grace period of the Watcher is over; a
+// failure to close them is reported as ReloadFailed.
+type ShardRemoved struct {
+	Shard Shard
+}
+
+// ShardStateChanged is reported when the read only flag or the weight of a
+// Shard was changed.
+type ShardStateChanged struct {
+	Shard       Shard
+	ReadOnly    bool
+	Weight      int
+	WasReadOnly bool
+	WasWeight   int
+}
+
+// ReloadFailed is reported when the topology file could not be applied.
+type ReloadFailed struct {
+	Err error
+}
+
+func (ShardAdded) event()        {}
+func (ShardRemoved) event()      {}
+func (ShardStateChanged) event() {}
+func (ReloadFailed) event()      {}
+
+type watcher struct {
+	c        Cluster
+	path     string
+	interval time.Duration
+	grace    time.Duration
+	conf     map[string]ShardConfig
+	mod      time.Time
+	size     int64
+	subs     []func(Event)
+	subsLock sync.RWMutex
+	mu       sync.Mutex
+}
+
+func (w *watcher) Subscribe(fn func(Event)) {
+	w.subsLock.Lock()
+	w.subs = append(w.subs, fn)
+	w.subsLock.Unlock()
+}
+
+func (w *watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.reload()
+}
+
+func (w *watcher) Run(ctx context.Context) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	if err := w.check(); err != nil {
+		w.emit(ReloadFailed{err})
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := w.check(); err != nil {
+				w.emit(ReloadFailed{err})
+			}
+		}
+	}
+}
+
+// check reloads the file if its modification time or size has changed
+// since the last successful reload.
+func (w *watcher) check() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return wrapErr(err, "failed to stat config")
+	}
+	if fi.ModTime().Equal(w.mod) && fi.Size() == w.size {
+		return nil
+	}
+	return w.reload()
+}
+
+func (w *watcher) reload() error {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return wrapErr(err, "failed to stat config")
+	}
+	cfg, err := LoadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	if err := w.apply(cfg); err != nil {
+		return err
+	}
+	w.mod, w.size = fi.ModTime(), fi.Size()
+	return nil
+}
+
+// apply adds new shards first, then updates the existing ones and removes
+// the missing ones last, so the Cluster never runs out of shards midway.
+// A shard whose driver or DSNs have changed since the previous reload is
+// replaced: the new connection is opened and swapped in before the old one
+// is closed, so the shard stays available if the new one fails to open.
+func (w *watcher) apply(cfg *Config) error {
+	all := w.c.All()
+	current := make(map[string]Shard, len(all))
+	for _, s := range all {
+		current[s.ID()] = s
+	}
+	wanted := make(map[string]struct{}, len(cfg.Shards))
+	for i, sc := range cfg.Shards {
+		wanted[sc.ID] = struct{}{}
+		if _, exists := current[sc.ID]; !exists {
+			if err := w.add(sc); err != nil {
+				return wrapErr(err, cfg.entry(i))
+			}
+		}
+	}
+	var replaced []ShardConfig
+	for _, sc := range cfg.Shards {
+		s, exists := current[sc.ID]
+		if !exists {
+			continue
+		}
+		if prev, known := w.conf[sc.ID]; known && !prev.sameConn(sc) {
+			replaced = append(replaced, sc)
+			continue
+		}
+		w.conf[sc.ID] = sc
+		w.update(s, sc)
+	}
+	for _, s := range all {
+		if _, exists := wanted[s.ID()]; !exists {
+			if err := w.remove(s.ID()); err != nil {
+				return wrapErr(err, "failed to remove shard '"+s.ID()+"'")
+			}
+		}
+	}
+	for _, sc := range replaced {
+		if err := w.replace(sc); err != nil {
+			return wrapErr(err, "failed to replace shard '"+sc.ID+"'")
+		}
+	}
+	return nil
+}
+
+func (w *watcher) add(sc ShardConfig) error {
+	s, err := sc.Open()
+	if err != nil {
+		return err
+	}
+	if err := w.c.AddShard(s); err != nil {
+		_ = closeShard(s)
+		return err
+	}
+	w.conf[sc.ID] = sc
+	w.emit(ShardAdded{s})
+	return nil
+}
+
+func (w *watcher) replace(sc ShardConfig) error {
+	s, err := sc.Open()
+	if err != nil {
+		return err
+	}
+	old, err := w.c.ReplaceShard(s)
+	if err != nil {
+		_ = closeShard(s)
+		return err
+	}
+	w.conf[sc.ID] = sc
+	w.emit(ShardRemoved{old})
+	w.emit(ShardAdded{s})
+	w.retire(old)
+	return nil
+}
+
+func (w *watcher) update(s Shard, sc ShardConfig) {
+	e := ShardStateChanged{
+		Shard:       s,
+		ReadOnly:    sc.ReadOnly,
+		Weight:      sc.weight(),
+		WasReadOnly: s.ReadOnly(),
+		WasWeight:   s.Weight(),
+	}
+	if e.ReadOnly == e.WasReadOnly && e.Weight == e.WasWeight {
+		return
+	}
+	s.SetReadOnly(e.ReadOnly)
+	s.SetWeight(e.Weight)
+	w.emit(e)
+}
+
+func (w *watcher) remove(id string) error {
+	s, err := w.c.RemoveShard(id)
+	if err != nil {
+		return err
+	}
+	delete(w.conf, id)
+	w.emit(ShardRemoved{s})
+	w.retire(s)
+	return nil
+}
+
+// retire closes s once the grace period is over.
+func (w *watcher) retire(s Shard) {
+	time.AfterFunc(w.grace, func() {
+		if err := closeShard(s); err != nil {
+			w.emit(ReloadFailed{wrapErr(err, "failed to close shard '"+s.ID()+"'")})
+		}
+	})
+}
+
+func (w *watcher) emit(e Event) {
+	w.subsLock.RLock()
+	defer w.subsLock.RUnlock()
+	for _, fn := range w.subs {
+		fn(e)
+	}
+}
@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, path, json string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(json), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// describe turns events into comparable strings.
+func describe(events []Event) []string {
+	res := make([]string, 0, len(events))
+	for _, e := range events {
+		switch e := e.(type) {
+		case ShardAdded:
+			res = append(res, "added "+e.Shard.ID())
+		case ShardRemoved:
+			res = append(res, "removed "+e.Shard.ID())
+		case ShardStateChanged:
+			res = append(res, "changed "+e.Shard.ID())
+		case ReloadFailed:
+			res = append(res, "failed")
+		}
+	}
+	return res
+}
+
+// loadWatched returns the Config at path and the Cluster built from it.
+func loadWatched(t *testing.T, path string) (*Config, Cluster) {
+	t.Helper()
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClusterFromConfig(testIdGen, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, c
+}
+
+func Test_watcher_Reload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	writeConfig(t, path, `{"shards": [
+		{"id": "000001", "driver": "cluster-fake", "dsn": "watch-one"},
+		{"id": "000002", "driver": "cluster-fake", "dsn": "watch-two"}
+	]}`)
+	cfg, c := loadWatched(t, path)
+	w := NewWatcher(c, cfg, path, time.Hour, time.Hour)
+	var events []Event
+	w.Subscribe(func(e Event) {
+		events = append(events, e)
+	})
+	tests := []struct {
+		name    string
+		json    string
+		want    []string
+		wantIds []string
+		wantErr bool
+	}{
+		{
+			"unchanged",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "watch-one"},
+				{"id": "000002", "driver": "cluster-fake", "dsn": "watch-two"}
+			]}`,
+			[]string{},
+			[]string{"000001", "000002"},
+			false,
+		},
+		{
+			"add, change and remove",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "watch-one", "read_only": true},
+				{"id": "000003", "driver": "cluster-fake", "dsn": "watch-three", "weight": 2}
+			]}`,
+			[]string{"added 000003", "changed 000001", "removed 000002"},
+			[]string{"000001", "000003"},
+			false,
+		},
+		{
+			"replace",
+			`{"shards": [
+				{"id": "000001", "driver": "cluster-fake", "dsn": "watch-one", "read_only": true},
+				{"id": "000003", "driver": "cluster-fake", "dsn": "watch-three-b", "weight": 2}
+			]}`,
+			[]string{"removed 000003", "added 000003"},
+			[]string{"000001", "000003"},
+			false,
+		},
+		{
+			"invalid",
+			`{"shards": [{"id": "000001", "driver": "cluster-fake"}]}`,
+			[]string{},
+			[]string{"000001", "000003"},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events = events[:0]
+			writeConfig(t, path, tt.json)
+			if err := w.Reload(); (err != nil) != tt.wantErr {
+				t.Errorf("Reload() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got := describe(events); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Reload() events = %v, want %v", got, tt.want)
+			}
+			ids := make([]string, 0)
+			for _, s := range c.All() {
+				ids = append(ids, s.ID())
+			}
+			if !reflect.DeepEqual(ids, tt.wantIds) {
+				t.Errorf("All() = %v, want %v", ids, tt.wantIds)
+			}
+		})
+	}
+	s, err := c.One("100@000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.ReadOnly() {
+		t.Error("ReadOnly() = false after reload")
+	}
+}
+
+func Test_watcher_Reload_replace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "replace-one"}]}`)
+	cfg, c := loadWatched(t, path)
+	w := NewWatcher(c, cfg, path, time.Hour, 100*time.Millisecond)
+	old := c.All()[0]
+	// The first reload compares against the Config the Cluster was built from.
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-none", "dsn": "replace-one"}]}`)
+	if err := w.Reload(); err == nil {
+		t.Error("Reload() error = nil for a shard that cannot be opened")
+	}
+	if got := c.All(); len(got) != 1 || got[0] != old {
+		t.Errorf("All() = %v after a failed replace, want the old shard", got)
+	}
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "replace-one-b"}]}`)
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.All(); len(got) != 1 || got[0] == old {
+		t.Errorf("All() = %v, want the replaced shard", got)
+	}
+	if err := old.Conn().Ping(); err != nil {
+		t.Errorf("Ping() error = %v on the replaced shard within the grace period", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for old.Conn().Ping() == nil && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := old.Conn().Ping(); err == nil {
+		t.Error("Ping() error = nil on the replaced shard after the grace period")
+	}
+}
+
+func TestNewWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "new-watcher"}]}`)
+	cfg, c := loadWatched(t, path)
+	w := NewWatcher(c, cfg, path, 0, 0)
+	if got := w.(*watcher); got.interval != 10*time.Second || got.grace != 30*time.Second {
+		t.Errorf("interval = %v, grace = %v, want the defaults", got.interval, got.grace)
+	}
+}
+
+func Test_watcher_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cluster.json")
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "run-one"}]}`)
+	cfg, c := loadWatched(t, path)
+	w := NewWatcher(c, cfg, path, 5*time.Millisecond, time.Hour)
+	ch := make(chan Event, 10)
+	w.Subscribe(func(e Event) {
+		ch <- e
+	})
+	// The change is written before Run, so its first check sees it whole.
+	writeConfig(t, path, `{"shards": [{"id": "000001", "driver": "cluster-fake", "dsn": "run-one", "weight": 5}]}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.Run(ctx)
+	}()
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+	select {
+	case e := <-ch:
+		sc, ok := e.(ShardStateChanged)
+		if !ok || sc.Weight != 5 || sc.WasWeight != 1 {
+			t.Errorf("Run() event = %#v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not report the change")
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-ch:
+		if _, ok := e.(ReloadFailed); !ok {
+			t.Errorf("Run() event = %#v, want ReloadFailed", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Run() did not report the failure")
+	}
+}
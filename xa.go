@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"context"
+	"database/sql"
+)
+
+// XADialect issues the statements of a two-phase commit. A branch is begun,
+// run and prepared on a single connection; prepared branches can be
+// committed or rolled back from any connection.
+type XADialect interface {
+	// Begin starts the branch xid on conn.
+	Begin(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// Prepare ends and prepares the branch xid on conn.
+	Prepare(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// Abort rolls back the branch xid on conn before it was prepared.
+	Abort(ctx context.Context, conn *sql.Conn, xid string) error
+
+	// CommitPrepared commits the prepared branch xid.
+	CommitPrepared(ctx context.Context, db Execer, xid string) error
+
+	// RollbackPrepared rolls back the prepared branch xid.
+	RollbackPrepared(ctx context.Context, db Execer, xid string) error
+
+	// Recover returns the IDs of all prepared branches of db.
+	Recover(ctx context.Context, db *sql.DB) ([]string, error)
+}
+
+// Execer is implemented by *sql.DB, *sql.Conn and *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+var (
+	// PostgresXA uses PREPARE TRANSACTION. The server must allow prepared
+	// transactions (max_prepared_transactions > 0).
+	PostgresXA XADialect = postgresXA{}
+
+	// MySQLXA uses XA transactions.
+	MySQLXA XADialect = mysqlXA{}
+)
+
+type postgresXA struct{}
+
+func (postgresXA) Begin(ctx context.Context, conn *sql.Conn, _ string) error {
+	_, err := conn.ExecContext(ctx, "BEGIN")
+	return err
+}
+
+func (postgresXA) Prepare(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "PREPARE TRANSACTION '"+xid+"'")
+	return err
+}
+
+func (postgresXA) Abort(ctx context.Context, conn *sql.Conn, _ string) error {
+	_, err := conn.ExecContext(ctx, "ROLLBACK")
+	return err
+}
+
+func (postgresXA) CommitPrepared(ctx context.Context, db Execer, xid string) error {
+	_, err := db.ExecContext(ctx, "COMMIT PREPARED '"+xid+"'")
+	return err
+}
+
+func (postgresXA) RollbackPrepared(ctx context.Context, db Execer, xid string) error {
+	_, err := db.ExecContext(ctx, "ROLLBACK PREPARED '"+xid+"'")
+	return err
+}
+
+func (postgresXA) Recover(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT gid FROM pg_prepared_xacts WHERE database = current_database()")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var xid string
+		if err := rows.Scan(&xid); err != nil {
+			return nil, err
+		}
+		res = append(res, xid)
+	}
+	return res, rows.Err()
+}
+
+type mysqlXA struct{}
+
+func (mysqlXA) Begin(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, err := conn.ExecContext(ctx, "XA START '"+xid+"'")
+	return err
+}
+
+func (mysqlXA) Prepare(ctx context.Context, conn *sql.Conn, xid string) error {
+	if _, err := conn.ExecContext(ctx, "XA END '"+xid+"'"); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, "XA PREPARE '"+xid+"'")
+	return err
+}
+
+// Abort ignores the XA END error, as the branch may already be ended when
+// XA PREPARE failed.
+func (mysqlXA) Abort(ctx context.Context, conn *sql.Conn, xid string) error {
+	_, _ = conn.ExecContext(ctx, "XA END '"+xid+"'")
+	_, err := conn.ExecContext(ctx, "XA ROLLBACK '"+xid+"'")
+	return err
+}
+
+func (mysqlXA) CommitPrepared(ctx context.Context, db Execer, xid string) error {
+	_, err := db.ExecContext(ctx, "XA COMMIT '"+xid+"'")
+	return err
+}
+
+func (mysqlXA) RollbackPrepared(ctx context.Context, db Execer, xid string) error {
+	_, err := db.ExecContext(ctx, "XA ROLLBACK '"+xid+"'")
+	return err
+}
+
+// Recover reads the data column of XA RECOVER, which holds the gtrid as
+// the branches are started without a bqual.
+func (mysqlXA) Recover(ctx context.Context, db *sql.DB) ([]string, error) {
+	rows, err := db.QueryContext(ctx, "XA RECOVER")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var (
+			format, gtrid, bqual int64
+			data                 string
+		)
+		if err := rows.Scan(&format, &gtrid, &bqual, &data); err != nil {
+			return nil, err
+		}
+		if gtrid >= 0 && gtrid < int64(len(data)) {
+			data = data[:gtrid]
+		}
+		res = append(res, data)
+	}
+	return res, rows.Err()
+}